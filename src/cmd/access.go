@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var (
+	accessRole string
+	accessTTL  time.Duration
+	accessOut  string
+)
+
+// accessCmd represents the access command
+var accessCmd = &cobra.Command{
+	Use:   "access",
+	Short: "Grant and revoke cluster access for teammates",
+}
+
+// accessGrantCmd represents the access grant command
+var accessGrantCmd = &cobra.Command{
+	Use:   "grant <user>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Issue a kubeconfig for a user bound to a ClusterRole",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		user := args[0]
+
+		out := accessOut
+		if out == "" {
+			out = user + ".kubeconfig"
+		}
+
+		// Keep stdout for the kubeconfig alone
+		if out == "-" {
+			configFile.Progress = os.Stderr
+		}
+
+		if err := infra.GrantAccess(configFile, user, accessRole, accessTTL, out); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// accessRevokeCmd represents the access revoke command
+var accessRevokeCmd = &cobra.Command{
+	Use:   "revoke <user>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Remove a user's access to the cluster",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		if err := infra.RevokeAccess(configFile, args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	accessGrantCmd.Flags().StringVar(&accessRole, "role", "view", "ClusterRole to bind the user to: "+strings.Join(infra.AccessRoles, "|"))
+	accessGrantCmd.Flags().DurationVar(&accessTTL, "ttl", 24*time.Hour, "how long the user's certificate is valid")
+	accessGrantCmd.Flags().StringVar(&accessOut, "out", "", "where to write the user's kubeconfig, - for stdout (default \"./<user>.kubeconfig\")")
+
+	accessCmd.AddCommand(accessGrantCmd)
+	accessCmd.AddCommand(accessRevokeCmd)
+	rootCmd.AddCommand(accessCmd)
+}
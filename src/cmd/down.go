@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"log"
+
 	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
 	"github.com/spf13/cobra"
 )
@@ -11,7 +13,12 @@ var downCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(0),
 	Short: "Teardown AWS infrastructure and k3s cluster",
 	Run: func(cmd *cobra.Command, args []string) {
-		infra.Down(configFile.Region, configFile.InstanceType)
+		readConfigFile()
+		validateConfigFile()
+
+		if err := infra.Down(configFile); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var (
+	execNodes    string
+	execParallel int
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec -- command...",
+	Short: "Run a command on several nodes in parallel",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.ArgsLenAtDash() != 0 || len(args) == 0 {
+			return errors.New("the command to run must follow --")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		if err := infra.Exec(configFile, execNodes, args, execParallel); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	execCmd.Flags().StringVar(&execNodes, "nodes", "all", "nodes to run the command on: all, servers, agents or a node name")
+	execCmd.Flags().IntVar(&execParallel, "parallel", 5, "maximum number of nodes to run the command on at once")
+
+	rootCmd.AddCommand(execCmd)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+// resetCmd represents the reset command
+var resetCmd = &cobra.Command{
+	Use:   "reset",
+	Args:  cobra.MaximumNArgs(0),
+	Short: "Reinstall k3s from scratch without recreating AWS infrastructure",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+		setProgressOutput()
+
+		if err := infra.Reset(configFile, kubeconfigOpts); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	addKubeconfigFlags(resetCmd)
+	rootCmd.AddCommand(resetCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var snapshotName string
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save, list and restore etcd snapshots",
+}
+
+// snapshotSaveCmd represents the snapshot save command
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save",
+	Args:  cobra.MaximumNArgs(0),
+	Short: "Take an etcd snapshot of the cluster",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		if err := infra.SaveSnapshot(configFile, snapshotName); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// snapshotListCmd represents the snapshot list command
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Args:  cobra.MaximumNArgs(0),
+	Short: "List etcd snapshots",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		if err := infra.ListSnapshots(configFile); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// snapshotRestoreCmd represents the snapshot restore command
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <name>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Reset the cluster to an etcd snapshot",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		if err := infra.RestoreSnapshot(configFile, args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	snapshotSaveCmd.Flags().StringVar(&snapshotName, "name", "", "name prefix of the snapshot")
+
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/spf13/cobra"
+)
+
+// sshCmd represents the ssh command
+var sshCmd = &cobra.Command{
+	Use:   "ssh [node] [-- command...]",
+	Short: "Open a shell on a node, or run a command on it",
+	Args: func(cmd *cobra.Command, args []string) error {
+		nodeArgs := args
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			nodeArgs = args[:dash]
+		}
+
+		return cobra.MaximumNArgs(1)(cmd, nodeArgs)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		var nodeName string
+		var command []string
+
+		nodeArgs := args
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			nodeArgs, command = args[:dash], args[dash:]
+		}
+
+		if len(nodeArgs) == 1 {
+			nodeName = nodeArgs[0]
+		}
+
+		err := infra.Shell(configFile, nodeName, command)
+
+		// Exit with the remote exit status like ssh does
+		var commandErr *ssh.RemoteCommandError
+		if errors.As(err, &commandErr) && commandErr.ExitStatus >= 0 {
+			os.Exit(commandErr.ExitStatus)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sshCmd)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Args:  cobra.MaximumNArgs(0),
+	Short: "Display the effective cluster configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		printStatus()
+	},
+}
+
+func printStatus() {
+	effective, err := infra.EffectiveComponents(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	version := configFile.K3s.Version
+	if version == "" {
+		version = "latest stable"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Region:\t%s\n", configFile.Region)
+	fmt.Fprintf(w, "Instance type:\t%s\n", configFile.InstanceType)
+	fmt.Fprintf(w, "k3s version:\t%s\n", version)
+	fmt.Fprintf(w, "Air-gapped:\t%t\n", configFile.K3s.Airgap)
+
+	snapshots := "disabled"
+	if configFile.Snapshots.S3 {
+		snapshots = "local and S3"
+	} else if configFile.Snapshots.Enabled {
+		snapshots = "local"
+	}
+
+	fmt.Fprintf(w, "Snapshots:\t%s\n", snapshots)
+
+	fmt.Fprintln(w, "\nComponents:")
+	for _, name := range infra.PackagedComponents {
+		state := "disabled"
+		if effective[name] {
+			state = "enabled"
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\n", name, state)
+	}
+
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
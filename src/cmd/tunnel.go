@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tunnelForwards []string
+	tunnelOpts     = types.TunnelOptions{}
+)
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Args:  cobra.MaximumNArgs(0),
+	Short: "Forward local ports and run a SOCKS proxy over SSH to the cluster",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		for _, spec := range tunnelForwards {
+			forward, err := infra.ParseForward(spec)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			tunnelOpts.Forwards = append(tunnelOpts.Forwards, forward)
+		}
+
+		if err := infra.Tunnel(configFile, tunnelOpts); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	tunnelCmd.Flags().StringArrayVarP(&tunnelForwards, "local", "L", nil, "forward a local port to an address reached from the server: [bind_address:]port:host:hostport")
+	tunnelCmd.Flags().StringVar(&tunnelOpts.SOCKSAddr, "socks", "", "run a SOCKS5 proxy on this local address, e.g. 127.0.0.1:1080")
+	tunnelCmd.Flags().StringVar(&tunnelOpts.KubeconfigOut, "kubeconfig-out", "", "write a kubeconfig that reaches the API server through the forward to its port")
+
+	rootCmd.AddCommand(tunnelCmd)
+}
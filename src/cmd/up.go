@@ -10,7 +10,10 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-var configFile = types.ConfigFile{}
+var (
+	configFile     = types.ConfigFile{}
+	kubeconfigOpts = types.KubeconfigOptions{}
+)
 
 // upCmd represents the up command
 var (
@@ -21,7 +24,11 @@ var (
 		Run: func(cmd *cobra.Command, args []string) {
 			readConfigFile()
 			validateConfigFile()
-			infra.Up(configFile.Region, configFile.InstanceType)
+			setProgressOutput()
+
+			if err := infra.Up(configFile, kubeconfigOpts); err != nil {
+				log.Fatal(err)
+			}
 		},
 	}
 )
@@ -51,8 +58,42 @@ func validateConfigFile() {
 	if configFile.InstanceType == "" {
 		log.Fatal("Instance type must be set")
 	}
+
+	if err := infra.ValidateComponents(configFile.Components, configFile.K3s.Config); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := infra.ValidateRegistries(configFile.Registries); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadCluster loads the cluster's nodes, and its ephemeral SSH key if it uses one, from the Pulumi stack
+func loadCluster() {
+	var err error
+	if configFile, err = infra.LoadCluster(configFile); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// addKubeconfigFlags adds the flags that control where the kubeconfig is written
+func addKubeconfigFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&kubeconfigOpts.Merge, "kubeconfig-merge", true, "merge the kubeconfig into $KUBECONFIG or ~/.kube/config under a context named after the cluster")
+	cmd.Flags().BoolVar(&kubeconfigOpts.SwitchContext, "kubeconfig-switch-context", false, "make the merged context the current context")
+	cmd.Flags().StringVar(&kubeconfigOpts.Out, "kubeconfig-out", "", "where to write the kubeconfig: a file path, - for stdout, ssm://<parameter> or secretsmanager://<secret> (default \"./kubeconfig\")")
+}
+
+// setProgressOutput sends all progress output to stderr when the kubeconfig is written to stdout,
+// so stdout contains nothing but the kubeconfig
+func setProgressOutput() {
+	kubeconfigOpts.Stdout = os.Stdout
+
+	if kubeconfigOpts.Out == "-" {
+		configFile.Progress = os.Stderr
+	}
 }
 
 func init() {
+	addKubeconfigFlags(upCmd)
 	rootCmd.AddCommand(upCmd)
 }
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var upgradeVersion string
+
+// upgradeCmd represents the upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Args:  cobra.MaximumNArgs(0),
+	Short: "Upgrade k3s in place, one node at a time",
+	Run: func(cmd *cobra.Command, args []string) {
+		readConfigFile()
+		validateConfigFile()
+		loadCluster()
+
+		if err := infra.ValidateK3sVersion(upgradeVersion); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := infra.Upgrade(configFile, upgradeVersion); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().StringVar(&upgradeVersion, "to", "", "k3s version to upgrade to, e.g. v1.27.4+k3s1")
+	upgradeCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(upgradeCmd)
+}
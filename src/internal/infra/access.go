@@ -0,0 +1,316 @@
+package infra
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// minCertificateTTL is the shortest certificate lifetime Kubernetes accepts
+const minCertificateTTL = 10 * time.Minute
+
+// AccessRoles are the ClusterRoles a user can be granted
+var AccessRoles = []string{"view", "edit", "admin"}
+
+// GrantAccess issues a client certificate for a user, signed by the cluster's client CA,
+// binds the user to a ClusterRole and writes a kubeconfig that authenticates as the user
+// to the out file path, or to stdout if out is "-". Progress goes to the config's progress writer,
+// which must not be stdout when out is "-"
+func GrantAccess(config types.ConfigFile, user, role string, ttl time.Duration, out string) error {
+	if err := validateAccessUser(user); err != nil {
+		return err
+	}
+
+	if !containsString(AccessRoles, role) {
+		return fmt.Errorf("unknown role %q, must be one of: %s", role, strings.Join(AccessRoles, ", "))
+	}
+
+	if ttl < minCertificateTTL {
+		return fmt.Errorf("ttl must be at least %s", minCertificateTTL)
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	adminKubeconfig, err := fetchKubeconfig(config, connections)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := newKubeClient(adminKubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultReadinessTimeout)
+	defer cancel()
+
+	keyPEM, certPEM, err := issueClientCertificate(ctx, clientset, user, ttl)
+	if err != nil {
+		return err
+	}
+
+	if err := bindClusterRole(ctx, clientset, user, role); err != nil {
+		return err
+	}
+
+	kubeconfig, err := userKubeconfig(adminKubeconfig, user, certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	destination := out
+	if out == "-" {
+		destination = "stdout"
+		_, err = os.Stdout.Write(kubeconfig)
+	} else {
+		err = writeFileAtomic(out, kubeconfig)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(config.ProgressWriter(), "Granted %s the %s role until %s, kubeconfig written to %s\n", user, role, time.Now().Add(ttl).Format(time.RFC3339), destination)
+
+	return nil
+}
+
+// RevokeAccess removes a user's ClusterRoleBinding. Kubernetes cannot revoke client certificates,
+// so the user's certificate still authenticates until it expires, but it no longer grants any access
+func RevokeAccess(config types.ConfigFile, user string) error {
+	if err := validateAccessUser(user); err != nil {
+		return err
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	adminKubeconfig, err := fetchKubeconfig(config, connections)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := newKubeClient(adminKubeconfig)
+	if err != nil {
+		return err
+	}
+
+	err = clientset.RbacV1().ClusterRoleBindings().Delete(context.Background(), accessBindingName(user), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("user %s has not been granted access", user)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(config.ProgressWriter(), "Revoked access for %s\n", user)
+
+	return nil
+}
+
+// issueClientCertificate creates a private key and has the cluster sign a client certificate
+// for it through a CertificateSigningRequest
+func issueClientCertificate(ctx context.Context, clientset *kubernetes.Clientset, user string, ttl time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: user},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expirationSeconds := int32(ttl.Seconds())
+
+	csr, err := clientset.CertificatesV1().CertificateSigningRequests().Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: projectName + "-" + toResourceName(user) + "-",
+			Labels:       map[string]string{managedByLabel: projectName},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+			SignerName:        certificatesv1.KubeAPIServerClientSignerName,
+			ExpirationSeconds: &expirationSeconds,
+			Usages:            []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The CSR is only needed until the certificate has been issued
+	defer clientset.CertificatesV1().CertificateSigningRequests().Delete(context.Background(), csr.Name, metav1.DeleteOptions{})
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateApproved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "ApprovedByEc2K3s",
+		Message: "Approved by ec2-k3s access grant",
+	})
+
+	if _, err := clientset.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{}); err != nil {
+		return nil, nil, err
+	}
+
+	certPEM, err := waitCertificateIssued(ctx, clientset, csr.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), certPEM, nil
+}
+
+func waitCertificateIssued(ctx context.Context, clientset *kubernetes.Clientset, name string) ([]byte, error) {
+	for {
+		csr, err := clientset.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, condition := range csr.Status.Conditions {
+			if condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed {
+				return nil, fmt.Errorf("certificate signing request %s %s: %s", name, condition.Type, condition.Message)
+			}
+		}
+
+		if len(csr.Status.Certificate) > 0 {
+			return csr.Status.Certificate, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for certificate signing request %s to be signed", name)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// bindClusterRole creates or replaces the ClusterRoleBinding that grants a user a ClusterRole
+func bindClusterRole(ctx context.Context, clientset *kubernetes.Clientset, user, role string) error {
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   accessBindingName(user),
+			Labels: map[string]string{managedByLabel: projectName},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     role,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.UserKind,
+				Name:     user,
+			},
+		},
+	}
+
+	bindings := clientset.RbacV1().ClusterRoleBindings()
+
+	// The role reference of a binding cannot be changed, so an existing binding is replaced
+	err := bindings.Delete(ctx, binding.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	_, err = bindings.Create(ctx, binding, metav1.CreateOptions{})
+
+	return err
+}
+
+// userKubeconfig builds a kubeconfig for the user that connects to the same cluster as the admin kubeconfig
+func userKubeconfig(adminKubeconfig []byte, user string, certPEM, keyPEM []byte) ([]byte, error) {
+	adminConfig, err := clientcmd.Load(adminKubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	adminContext, ok := adminConfig.Contexts[adminConfig.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no current context")
+	}
+
+	name := user + "@" + adminContext.Cluster
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters[adminContext.Cluster] = adminConfig.Clusters[adminContext.Cluster]
+	config.AuthInfos[user] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: certPEM,
+		ClientKeyData:         keyPEM,
+	}
+	config.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  adminContext.Cluster,
+		AuthInfo: user,
+	}
+	config.CurrentContext = name
+
+	return clientcmd.Write(*config)
+}
+
+// validateAccessUser rejects names that are empty or impersonate Kubernetes system users
+func validateAccessUser(user string) error {
+	if user == "" {
+		return fmt.Errorf("user must be set")
+	}
+
+	if strings.HasPrefix(user, "system:") {
+		return fmt.Errorf("user %q must not start with system:", user)
+	}
+
+	return nil
+}
+
+func accessBindingName(user string) string {
+	return projectName + "-access-" + toResourceName(user)
+}
+
+// toResourceName converts a user name such as an email address into a valid Kubernetes resource name
+func toResourceName(user string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+
+		return '-'
+	}, strings.ToLower(user))
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
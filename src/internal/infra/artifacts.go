@@ -0,0 +1,283 @@
+package infra
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+)
+
+const (
+	k3sArch             string = "amd64"
+	k3sReleaseURL       string = "https://github.com/k3s-io/k3s/releases/download"
+	k3sSourceURL        string = "https://raw.githubusercontent.com/k3s-io/k3s"
+	k3sStableChannelURL string = "https://update.k3s.io/v1-release/channels/stable"
+	remoteInstallScript string = "/usr/local/bin/k3s-install.sh"
+	airgapImagesDir     string = "/var/lib/rancher/k3s/agent/images"
+)
+
+// k3sArtifact is a file that is downloaded to the workstation,
+// verified and uploaded to the ec2 instance
+type k3sArtifact struct {
+	fileName   string
+	url        string
+	remotePath string
+	mode       os.FileMode
+
+	// checksumsURL is a sha256sum formatted file that lists the artifact's checksum
+	checksumsURL string
+}
+
+// k3sArtifacts returns the files needed to install a k3s version, including
+// the images needed to run without the ec2 instance reaching the internet if airgap is set
+func k3sArtifacts(version string, airgap bool) []k3sArtifact {
+	escapedVersion := strings.ReplaceAll(version, "+", "%2B")
+	releaseURL := k3sReleaseURL + "/" + escapedVersion
+	releaseChecksumsURL := releaseURL + "/sha256sum-" + k3sArch + ".txt"
+
+	// The install script is taken from the release's source tree so it matches the pinned version
+	installScriptURL := k3sSourceURL + "/" + escapedVersion + "/install.sh"
+
+	artifacts := []k3sArtifact{
+		{
+			fileName:     "install.sh",
+			url:          installScriptURL,
+			remotePath:   remoteInstallScript,
+			mode:         0755,
+			checksumsURL: installScriptURL + ".sha256sum",
+		},
+		{
+			fileName:     "k3s",
+			url:          releaseURL + "/k3s",
+			remotePath:   "/usr/local/bin/k3s",
+			mode:         0755,
+			checksumsURL: releaseChecksumsURL,
+		},
+	}
+
+	if airgap {
+		imagesFile := "k3s-airgap-images-" + k3sArch + ".tar.zst"
+
+		artifacts = append(artifacts, k3sArtifact{
+			fileName:     imagesFile,
+			url:          releaseURL + "/" + imagesFile,
+			remotePath:   path.Join(airgapImagesDir, imagesFile),
+			mode:         0644,
+			checksumsURL: releaseChecksumsURL,
+		})
+	}
+
+	return artifacts
+}
+
+// resolveK3sVersion returns the pinned k3s version,
+// or the latest stable release if no version is pinned
+func resolveK3sVersion(version string) (string, error) {
+	if version != "" {
+		return version, nil
+	}
+
+	// The stable channel redirects to the GitHub release page of the latest stable version
+	resp, err := http.Get(k3sStableChannelURL)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed resolving stable k3s version: %s", resp.Status)
+	}
+
+	return path.Base(resp.Request.URL.Path), nil
+}
+
+// uploadK3sArtifacts downloads and verifies the files needed to install a k3s version
+// and copies them to the ec2 instance. Nothing is uploaded unless every file is verified
+func uploadK3sArtifacts(sshClient *ssh.SSHClient, version string, airgap bool, progress io.Writer) error {
+	cacheDir, err := getArtifactCacheDir(version)
+	if err != nil {
+		return err
+	}
+
+	artifacts := k3sArtifacts(version, airgap)
+	localPaths := make([]string, 0, len(artifacts))
+	checksums := map[string]map[string]string{}
+
+	for _, artifact := range artifacts {
+		localPath, err := fetchArtifact(artifact, cacheDir, progress)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := checksums[artifact.checksumsURL]; !ok {
+			if checksums[artifact.checksumsURL], err = fetchChecksums(artifact.checksumsURL); err != nil {
+				return err
+			}
+		}
+
+		if err := verifyArtifact(localPath, artifact.fileName, checksums[artifact.checksumsURL]); err != nil {
+			// Remove the file so the next run downloads it again instead of reusing it
+			os.Remove(localPath)
+			return err
+		}
+
+		fmt.Fprintf(progress, "Verified checksum of %s\n", artifact.fileName)
+
+		localPaths = append(localPaths, localPath)
+	}
+
+	for i, artifact := range artifacts {
+		if err := uploadFile(sshClient, localPaths[i], artifact.remotePath, artifact.mode, progress); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchChecksums downloads a sha256sum formatted file and returns the checksums keyed by file name
+func fetchChecksums(url string) (map[string]string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed downloading checksums %s: %s", url, resp.Status)
+	}
+
+	checksums := map[string]string{}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// Each line looks like: <sha256>  <file name>
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		checksums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return checksums, nil
+}
+
+// verifyArtifact checks that a downloaded file matches its published checksum
+func verifyArtifact(localPath, fileName string, checksums map[string]string) error {
+	expected, ok := checksums[fileName]
+	if !ok {
+		return fmt.Errorf("no published checksum found for %s, refusing to install it", fileName)
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(expected, got) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s, refusing to install it", fileName, expected, got)
+	}
+
+	return nil
+}
+
+// fetchArtifact downloads an artifact into the cache directory
+// unless it has already been downloaded, and returns its local path
+func fetchArtifact(artifact k3sArtifact, cacheDir string, progress io.Writer) (string, error) {
+	localPath := filepath.Join(cacheDir, artifact.fileName)
+
+	if _, err := os.Stat(localPath); err == nil {
+		fmt.Fprintf(progress, "Using cached %s\n", localPath)
+		return localPath, nil
+	}
+
+	fmt.Fprintf(progress, "Downloading %s\n", artifact.url)
+
+	resp, err := http.Get(artifact.url)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed downloading %s: %s", artifact.url, resp.Status)
+	}
+
+	// Download to a temporary file so an interrupted download is never cached
+	tmpFile, err := os.CreateTemp(cacheDir, artifact.fileName+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpFile.Name(), localPath); err != nil {
+		return "", err
+	}
+
+	return localPath, nil
+}
+
+// uploadFile copies a local file to the ec2 instance
+func uploadFile(sshClient *ssh.SSHClient, localPath, remotePath string, mode os.FileMode, progress io.Writer) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	fmt.Fprintf(progress, "Uploading %s to %s\n", localPath, remotePath)
+
+	return sshClient.Upload(context.Background(), file, remotePath, mode)
+}
+
+// Get the directory that downloaded k3s artifacts are cached in
+func getArtifactCacheDir(version string) (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	cacheDir := filepath.Join(userCacheDir, projectName, version)
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+
+	return cacheDir, nil
+}
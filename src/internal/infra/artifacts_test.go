@@ -0,0 +1,33 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestVerifyArtifact tests that a downloaded file is only accepted if it matches its published checksum
+func TestVerifyArtifact(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "k3s")
+	if err := os.WriteFile(localPath, []byte("k3s binary"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// printf 'k3s binary' | sha256sum
+	checksums := map[string]string{
+		"k3s": "5d23b3ac2282f70670f79d7ec284a5ddd7e4b391c5c16d32e4c705eedc399008",
+	}
+
+	if err := verifyArtifact(localPath, "k3s", checksums); err != nil {
+		t.Error(err)
+	}
+
+	if err := verifyArtifact(localPath, "install.sh", checksums); err == nil {
+		t.Error("expected an error for a file without a published checksum")
+	}
+
+	checksums["k3s"] = "0000000000000000000000000000000000000000000000000000000000000000"
+	if err := verifyArtifact(localPath, "k3s", checksums); err == nil {
+		t.Error("expected an error for a checksum mismatch")
+	}
+}
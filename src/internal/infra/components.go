@@ -0,0 +1,131 @@
+package infra
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+// PackagedComponents are the components k3s deploys by default,
+// in the order they are displayed
+var PackagedComponents = []string{
+	"coredns",
+	"servicelb",
+	"traefik",
+	"local-storage",
+	"metrics-server",
+}
+
+// defaultComponents is whether each packaged component is enabled when it is not configured
+var defaultComponents = map[string]bool{
+	"coredns":        true,
+	"servicelb":      true,
+	"traefik":        false,
+	"local-storage":  true,
+	"metrics-server": true,
+}
+
+// ValidateComponents returns an error if a configured component is not a k3s packaged component,
+// or if a component is enabled in the components section but disabled through k3s.config.disable
+func ValidateComponents(components map[string]bool, k3sConfig map[string]interface{}) error {
+	for name := range components {
+		if _, ok := defaultComponents[name]; !ok {
+			return fmt.Errorf("unknown component %q, must be one of: %s", name, strings.Join(PackagedComponents, ", "))
+		}
+	}
+
+	disabled, err := k3sDisabled(k3sConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range disabled {
+		if components[name] {
+			return fmt.Errorf("component %q is enabled in components.%s but disabled in k3s.config.disable, remove one of them", name, name)
+		}
+	}
+
+	return nil
+}
+
+// k3sDisabled returns the components in a k3s config's disable flag,
+// which accepts comma separated values as well as a list
+func k3sDisabled(k3sConfig map[string]interface{}) ([]string, error) {
+	var values []interface{}
+
+	switch disable := k3sConfig["disable"].(type) {
+	case nil:
+	case string:
+		values = append(values, disable)
+	case []interface{}:
+		values = disable
+	default:
+		return nil, fmt.Errorf("k3s config %q must be a string or a list, got %T", "disable", disable)
+	}
+
+	var disabled []string
+	for _, value := range values {
+		for _, name := range strings.Split(fmt.Sprint(value), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				disabled = append(disabled, name)
+			}
+		}
+	}
+
+	return disabled, nil
+}
+
+// EffectiveComponents returns whether each packaged component is enabled in the k3s config
+// rendered for the cluster, which includes components disabled through k3s.config.disable
+func EffectiveComponents(config types.ConfigFile) (map[string]bool, error) {
+	k3sConfig, err := buildK3sConfig(config, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	effective := make(map[string]bool, len(PackagedComponents))
+	for _, name := range PackagedComponents {
+		effective[name] = true
+	}
+
+	disabled, err := k3sDisabled(k3sConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range disabled {
+		effective[name] = false
+	}
+
+	return effective, nil
+}
+
+// configuredComponents returns whether each packaged component is enabled
+// after applying the components section of the config file on top of the defaults
+func configuredComponents(components map[string]bool) map[string]bool {
+	effective := make(map[string]bool, len(defaultComponents))
+	for name, enabled := range defaultComponents {
+		effective[name] = enabled
+	}
+
+	for name, enabled := range components {
+		effective[name] = enabled
+	}
+
+	return effective
+}
+
+// disabledComponents returns the packaged components to pass to the k3s disable flag
+func disabledComponents(components map[string]bool) []string {
+	effective := configuredComponents(components)
+
+	var disabled []string
+	for _, name := range PackagedComponents {
+		if !effective[name] {
+			disabled = append(disabled, name)
+		}
+	}
+
+	return disabled
+}
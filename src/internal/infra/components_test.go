@@ -0,0 +1,73 @@
+package infra
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+// TestValidateComponents tests that only k3s packaged components can be configured
+func TestValidateComponents(t *testing.T) {
+	if err := ValidateComponents(map[string]bool{"traefik": true, "coredns": false}, nil); err != nil {
+		t.Error(err)
+	}
+
+	if err := ValidateComponents(map[string]bool{"nginx": true}, nil); err == nil {
+		t.Error("expected an error for an unknown component")
+	}
+
+	// Disabling a component in both places is consistent
+	if err := ValidateComponents(map[string]bool{"servicelb": false}, map[string]interface{}{"disable": []interface{}{"servicelb"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestValidateComponentsConflict tests that a component cannot be enabled and disabled through k3s.config.disable
+func TestValidateComponentsConflict(t *testing.T) {
+	for _, disable := range []interface{}{"servicelb,traefik", []interface{}{"traefik"}} {
+		err := ValidateComponents(map[string]bool{"traefik": true}, map[string]interface{}{"disable": disable})
+		if err == nil {
+			t.Errorf("expected: error for disable %v | got: nil", disable)
+			continue
+		}
+
+		for _, setting := range []string{"components.traefik", "k3s.config.disable"} {
+			if !strings.Contains(err.Error(), setting) {
+				t.Errorf("expected: error naming %s | got: %s", setting, err)
+			}
+		}
+	}
+}
+
+// TestEffectiveComponents tests that components disabled through the k3s config are reported as disabled
+func TestEffectiveComponents(t *testing.T) {
+	config := types.ConfigFile{
+		K3s: types.K3s{
+			Config: map[string]interface{}{"disable": "traefik,servicelb"},
+		},
+		Components: map[string]bool{
+			"coredns":        true,
+			"metrics-server": false,
+		},
+	}
+
+	effective, err := EffectiveComponents(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"coredns":        true,
+		"servicelb":      false,
+		"traefik":        false,
+		"local-storage":  true,
+		"metrics-server": false,
+	}
+
+	for name, enabled := range expected {
+		if effective[name] != enabled {
+			t.Errorf("expected: %s enabled %t | got: %t", name, enabled, effective[name])
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"golang.org/x/mod/semver"
+)
+
+// Actions InstallK3s can take depending on the state of the ec2 instance
+const (
+	actionInstall     string = "install"
+	actionUpgrade     string = "upgrade"
+	actionReconfigure string = "reconfigure"
+	actionSkip        string = "skip"
+)
+
+// remoteFile is a file k3s reads on startup.
+// A file with no content must not exist on the ec2 instance
+type remoteFile struct {
+	remotePath string
+	content    []byte
+	mode       os.FileMode
+}
+
+// k3sConfigFiles returns the k3s configuration files the ec2 instance should have
+func k3sConfigFiles(config types.ConfigFile, ip, snapshotBucket string) ([]remoteFile, error) {
+	k3sConfig, err := renderK3sConfig(config, ip, snapshotBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []remoteFile{
+		{remotePath: k3sConfigPath, content: k3sConfig, mode: 0600},
+	}
+
+	if len(config.Registries.Mirrors) == 0 && len(config.Registries.Configs) == 0 {
+		// Remove a registries.yaml left behind by a previous configuration
+		return append(files, remoteFile{remotePath: registriesConfigPath}), nil
+	}
+
+	registriesConfig, registryFiles, err := renderRegistries(config.Registries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range registryFiles {
+		content, err := os.ReadFile(file.localPath)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, remoteFile{remotePath: file.remotePath, content: content, mode: file.mode})
+	}
+
+	// registries.yaml may contain credentials, so it is only readable by root
+	return append(files, remoteFile{remotePath: registriesConfigPath, content: registriesConfig, mode: 0600}), nil
+}
+
+// installedK3sVersion returns the k3s version installed on the ec2 instance,
+// or an empty string if k3s is not installed
+func installedK3sVersion(sshClient *ssh.SSHClient) (string, error) {
+	versionCommand := "test -f /etc/systemd/system/k3s.service && k3s --version || true"
+
+	output, err := sshClient.ExecuteOutput(versionCommand)
+	if err != nil {
+		return "", err
+	}
+
+	// The first line looks like: k3s version v1.27.4+k3s1 (36645e73)
+	fields := strings.Fields(string(output.StdOut))
+	if len(fields) < 3 || fields[0] != "k3s" || fields[1] != "version" {
+		return "", nil
+	}
+
+	return fields[2], nil
+}
+
+// changedFiles returns the files whose contents on the ec2 instance differ from the desired contents
+func changedFiles(sshClient *ssh.SSHClient, files []remoteFile) ([]remoteFile, error) {
+	var changed []remoteFile
+
+	for _, file := range files {
+		// Compare checksums so secrets in the files are never sent back over the connection
+		checksumCommand := "sudo sha256sum " + ssh.ShellQuote(file.remotePath) + " 2>/dev/null || true"
+
+		output, err := sshClient.ExecuteOutput(checksumCommand)
+		if err != nil {
+			return nil, err
+		}
+
+		var remoteChecksum string
+		if fields := strings.Fields(string(output.StdOut)); len(fields) > 0 {
+			remoteChecksum = fields[0]
+		}
+
+		var desiredChecksum string
+		if file.content != nil {
+			sum := sha256.Sum256(file.content)
+			desiredChecksum = hex.EncodeToString(sum[:])
+		}
+
+		if remoteChecksum != desiredChecksum {
+			changed = append(changed, file)
+		}
+	}
+
+	return changed, nil
+}
+
+// writeRemoteFiles uploads files to the ec2 instance, or removes them if they have no content
+func writeRemoteFiles(sshClient *ssh.SSHClient, files []remoteFile, progress io.Writer) error {
+	for _, file := range files {
+		if file.content == nil {
+			fmt.Fprintf(progress, "Removing %s\n", file.remotePath)
+
+			if _, err := sshClient.ExecuteOutput("sudo rm -f " + ssh.ShellQuote(file.remotePath)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		fmt.Fprintf(progress, "Writing %s\n", file.remotePath)
+
+		if err := sshClient.Upload(context.Background(), bytes.NewReader(file.content), file.remotePath, file.mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chooseAction decides how to converge k3s on the ec2 instance to the desired state.
+// An unpinned desired version accepts whichever version is installed.
+// k3s cannot be downgraded, so a pinned version older than the installed one is an error
+func chooseAction(installedVersion, desiredVersion string, configChanged bool) (string, error) {
+	if installedVersion == "" {
+		return actionInstall, nil
+	}
+
+	if desiredVersion != "" && installedVersion != desiredVersion {
+		order, err := compareK3sVersions(installedVersion, desiredVersion)
+		if err != nil {
+			return "", err
+		}
+
+		if order > 0 {
+			return "", fmt.Errorf(
+				"k3s %s is installed, which is newer than the pinned k3s.version %s. k3s cannot be downgraded, set k3s.version to %s or newer",
+				installedVersion, desiredVersion, installedVersion,
+			)
+		}
+
+		if order < 0 {
+			return actionUpgrade, nil
+		}
+	}
+
+	if configChanged {
+		return actionReconfigure, nil
+	}
+
+	return actionSkip, nil
+}
+
+// ValidateK3sVersion returns an error if version is not a k3s release such as v1.27.4+k3s1
+func ValidateK3sVersion(version string) error {
+	if !semver.IsValid(version) {
+		return fmt.Errorf("invalid k3s version %q, must be a k3s release such as v1.27.4+k3s1", version)
+	}
+
+	return nil
+}
+
+// compareK3sVersions compares two k3s releases such as v1.27.4+k3s1 and returns -1, 0 or +1.
+// Releases of the same Kubernetes version are ordered by their k3s revision
+func compareK3sVersions(a, b string) (int, error) {
+	for _, version := range []string{a, b} {
+		if err := ValidateK3sVersion(version); err != nil {
+			return 0, err
+		}
+	}
+
+	if order := semver.Compare(a, b); order != 0 {
+		return order, nil
+	}
+
+	revisionA, revisionB := k3sRevision(a), k3sRevision(b)
+
+	switch {
+	case revisionA < revisionB:
+		return -1, nil
+	case revisionA > revisionB:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// k3sRevision returns the k3s revision of a release, e.g. 2 for v1.27.4+k3s2
+func k3sRevision(version string) int {
+	_, build, _ := strings.Cut(version, "+k3s")
+
+	revision, err := strconv.Atoi(build)
+	if err != nil {
+		return 0
+	}
+
+	return revision
+}
@@ -0,0 +1,51 @@
+package infra
+
+import "testing"
+
+// TestChooseAction tests how InstallK3s converges an ec2 instance to the desired state
+func TestChooseAction(t *testing.T) {
+	tests := []struct {
+		installedVersion string
+		desiredVersion   string
+		configChanged    bool
+		expected         string
+	}{
+		{"", "", false, actionInstall},
+		{"", "v1.27.4+k3s1", true, actionInstall},
+		{"v1.27.1+k3s1", "v1.27.4+k3s1", false, actionUpgrade},
+		{"v1.27.1+k3s1", "v1.27.4+k3s1", true, actionUpgrade},
+		{"v1.27.4+k3s1", "v1.27.4+k3s1", true, actionReconfigure},
+		{"v1.27.4+k3s1", "", true, actionReconfigure},
+		{"v1.27.4+k3s1", "v1.27.4+k3s1", false, actionSkip},
+		{"v1.27.4+k3s1", "", false, actionSkip},
+		{"v1.27.4+k3s1", "v1.27.4+k3s2", false, actionUpgrade},
+		{"v1.27.4+k3s1", "v1.28.1+k3s1", false, actionUpgrade},
+	}
+
+	for _, test := range tests {
+		got, err := chooseAction(test.installedVersion, test.desiredVersion, test.configChanged)
+		if err != nil {
+			t.Errorf("installed %q, desired %q: expected: no error | got: %s", test.installedVersion, test.desiredVersion, err)
+			continue
+		}
+
+		if test.expected != got {
+			t.Errorf("installed %q, desired %q, config changed %t: expected: %s | got: %s",
+				test.installedVersion, test.desiredVersion, test.configChanged, test.expected, got)
+		}
+	}
+}
+
+// TestChooseActionDowngrade tests that a pinned version older than the installed one is rejected
+func TestChooseActionDowngrade(t *testing.T) {
+	for _, desiredVersion := range []string{"v1.27.1+k3s1", "v1.27.4+k3s1", "v1.26.7+k3s2"} {
+		action, err := chooseAction("v1.27.4+k3s2", desiredVersion, true)
+		if err == nil {
+			t.Errorf("desired %q: expected: error | got: %s", desiredVersion, action)
+		}
+	}
+
+	if _, err := chooseAction("v1.27.4+k3s1", "1.27", false); err == nil {
+		t.Error("expected an error for an invalid desired version")
+	}
+}
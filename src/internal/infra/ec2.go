@@ -2,9 +2,11 @@ package infra
 
 import (
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/briandowns/spinner"
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
 	"github.com/lucasrod16/ec2-k3s/src/internal/types"
 	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
 
@@ -13,7 +15,7 @@ import (
 )
 
 // CreateSecurityGroup creates a security group in AWS
-func CreateSecurityGroup(ctx *pulumi.Context) (*types.Infrastructure, error) {
+func CreateSecurityGroup(ctx *pulumi.Context, progress io.Writer) (*types.Infrastructure, error) {
 	securityGroup, err := pec2.NewSecurityGroup(ctx, "security-group", &pec2.SecurityGroupArgs{
 		Description: pulumi.String("Allow all inbound traffic from the workstation IP address only"),
 		Ingress: pec2.SecurityGroupIngressArray{
@@ -23,7 +25,7 @@ func CreateSecurityGroup(ctx *pulumi.Context) (*types.Infrastructure, error) {
 				ToPort:      pulumi.Int(0),
 				Protocol:    pulumi.String("-1"),
 				CidrBlocks: pulumi.StringArray{
-					pulumi.String(utils.LocalIP()),
+					pulumi.String(utils.LocalIP(progress)),
 				},
 			},
 		},
@@ -51,10 +53,15 @@ func CreateSecurityGroup(ctx *pulumi.Context) (*types.Infrastructure, error) {
 }
 
 // CreateSSHKeyPair creates an SSH keypair in AWS
-func CreateSSHKeyPair(ctx *pulumi.Context) (*types.Infrastructure, error) {
+func CreateSSHKeyPair(ctx *pulumi.Context, name string, sshConfig types.SSH) (*types.Infrastructure, error) {
+	publicKey, err := ssh.PublicKey(sshConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	keypair, err := pec2.NewKeyPair(ctx, "ssh-keypair", &pec2.KeyPairArgs{
-		KeyName:   pulumi.String(utils.GetCurrentUser() + "-dev-keypair"),
-		PublicKey: pulumi.String(utils.GetPublicSSHKey()),
+		KeyName:   pulumi.String(name + "-keypair"),
+		PublicKey: pulumi.String(publicKey),
 	})
 	if err != nil {
 		return nil, err
@@ -65,14 +72,15 @@ func CreateSSHKeyPair(ctx *pulumi.Context) (*types.Infrastructure, error) {
 	}, nil
 }
 
-// CreateInstance creates an ec2 instance in AWS
-func CreateInstance(ctx *pulumi.Context, instanceType string) (*types.Infrastructure, error) {
+// CreateInstance creates an ec2 instance in AWS that accepts the given SSH keypair,
+// with an optional IAM instance profile
+func CreateInstance(ctx *pulumi.Context, name, instanceType string, keyName pulumi.StringInput, instanceProfile pulumi.StringInput, progress io.Writer) (*types.Infrastructure, error) {
 	computeInfra, err := getUbuntuAMI(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	securityInfra, err := CreateSecurityGroup(ctx)
+	securityInfra, err := CreateSecurityGroup(ctx, progress)
 	if err != nil {
 		return nil, err
 	}
@@ -80,10 +88,11 @@ func CreateInstance(ctx *pulumi.Context, instanceType string) (*types.Infrastruc
 	server, err := pec2.NewInstance(ctx, "ec2-instance", &pec2.InstanceArgs{
 		Ami:                 pulumi.String(computeInfra.Ami.ImageId),
 		InstanceType:        pulumi.String(instanceType),
-		KeyName:             pulumi.String(utils.GetCurrentUser() + "-dev-keypair"),
+		KeyName:             keyName,
 		VpcSecurityGroupIds: pulumi.StringArray{securityInfra.SecurityGroup.ID()},
+		IamInstanceProfile:  instanceProfile,
 		Tags: pulumi.StringMap{
-			"Name":  pulumi.String(utils.GetCurrentUser() + "-dev"),
+			"Name":  pulumi.String(name),
 			"Owner": pulumi.String(utils.InstanceOwner),
 		},
 	})
@@ -127,22 +136,22 @@ func getUbuntuAMI(ctx *pulumi.Context) (*types.Infrastructure, error) {
 	}, nil
 }
 
-// WaitInstanceReady waits for instance health checks to return "passed"
-func WaitInstanceReady(region string) error {
+// WaitInstanceReady waits for instance health checks to return "passed", reporting progress to progress
+func WaitInstanceReady(region, instanceId string, progress io.Writer) error {
 	// Set the timeout
 	timeout := 5 * time.Minute
 
 	// Set the start time for the timeout
 	startTime := time.Now()
 
-	s := spinner.New(spinner.CharSets[36], 1000*time.Millisecond)
+	s := spinner.New(spinner.CharSets[36], 1000*time.Millisecond, spinner.WithWriter(progress))
 	s.Start()
 
-	fmt.Println("Waiting for ec2 instance to be ready...")
+	fmt.Fprintln(progress, "Waiting for ec2 instance to be ready...")
 
 	for {
 		// Check the status of the instance
-		status, err := utils.GetInstanceStatus(region)
+		status, err := utils.GetInstanceStatus(region, instanceId)
 		if err != nil {
 			return err
 		}
@@ -150,7 +159,7 @@ func WaitInstanceReady(region string) error {
 		// Check if the instance status is "passed"
 		if status == "passed" {
 			s.Stop()
-			fmt.Println("Instance is ready!")
+			fmt.Fprintln(progress, "Instance is ready!")
 			return nil
 		}
 
@@ -0,0 +1,187 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+// Node selectors accepted by Exec in addition to node names
+const (
+	selectAll     string = "all"
+	selectServers string = "servers"
+	selectAgents  string = "agents"
+)
+
+// execResult is the outcome of running a command on one node
+type execResult struct {
+	node     types.Node
+	duration time.Duration
+	err      error
+}
+
+// Exec runs a command on the selected nodes, at most parallel at a time. Each line of output
+// is prefixed with the name of the node it came from, and a summary of every node's exit status
+// is printed at the end. It returns an error if the command failed on any node
+func Exec(config types.ConfigFile, selector string, command []string, parallel int) error {
+	if len(command) == 0 {
+		return fmt.Errorf("no command given")
+	}
+
+	if parallel < 1 {
+		return fmt.Errorf("parallel must be at least 1")
+	}
+
+	nodes, err := GetNodes(config)
+	if err != nil {
+		return err
+	}
+
+	selected, err := selectNodes(nodes, selector)
+	if err != nil {
+		return err
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	stdout := ssh.NewSyncWriter(os.Stdout)
+	stderr := ssh.NewSyncWriter(os.Stderr)
+
+	results := make([]execResult, len(selected))
+	limit := make(chan struct{}, parallel)
+
+	var wg sync.WaitGroup
+
+	for i, node := range selected {
+		i, node := i, node
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			results[i] = execNode(connections, node, remoteCommand(command), stdout, stderr)
+		}()
+	}
+
+	wg.Wait()
+
+	return summarizeExec(results)
+}
+
+// remoteCommand joins the arguments with spaces like ssh does, so the remote shell interprets them
+// and a single argument such as 'ls /var/lib/rancher | wc -l' runs as a pipeline
+func remoteCommand(args []string) string {
+	return strings.Join(args, " ")
+}
+
+// execNode runs a command on a node with its output prefixed with the node name
+func execNode(connections *ssh.Manager, node types.Node, command string, stdout, stderr *ssh.SyncWriter) execResult {
+	startTime := time.Now()
+
+	sshClient, err := connections.Connect(node)
+	if err != nil {
+		return execResult{node: node, duration: time.Since(startTime), err: err}
+	}
+
+	// The output is already printed, so none of it is buffered
+	_, err = sshClient.ExecuteOutput(
+		command,
+		ssh.WithStdout(stdout),
+		ssh.WithStderr(stderr),
+		ssh.WithPrefix("["+node.Name+"] "),
+		ssh.WithMaxBuffer(0),
+	)
+
+	return execResult{node: node, duration: time.Since(startTime), err: err}
+}
+
+// summarizeExec prints the exit status of every node
+func summarizeExec(results []execResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "\nNODE\tEXIT\tDURATION\tERROR")
+
+	failed := 0
+
+	for _, result := range results {
+		exitStatus := "0"
+		var message string
+
+		if result.err != nil {
+			failed++
+			exitStatus = "-"
+			message = result.err.Error()
+
+			var commandErr *ssh.RemoteCommandError
+			if errors.As(result.err, &commandErr) {
+				message = ""
+				if commandErr.ExitStatus >= 0 {
+					exitStatus = fmt.Sprint(commandErr.ExitStatus)
+				}
+
+				if commandErr.Signal != "" {
+					message = "killed by signal " + commandErr.Signal
+				}
+			}
+		}
+
+		// Keep the summary on one line per node
+		message = strings.SplitN(message, "\n", 2)[0]
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.node.Name, exitStatus, result.duration.Round(time.Millisecond), message)
+	}
+
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("command failed on %d of %d nodes", failed, len(results))
+	}
+
+	return nil
+}
+
+// selectNodes returns the nodes matching a selector: all, servers, agents or a node name
+func selectNodes(nodes []types.Node, selector string) ([]types.Node, error) {
+	var selected []types.Node
+
+	switch selector {
+	case selectAll:
+		return nodes, nil
+
+	case selectServers, selectAgents:
+		role := roleServer
+		if selector == selectAgents {
+			role = roleAgent
+		}
+
+		for _, node := range nodes {
+			if node.Role == role {
+				selected = append(selected, node)
+			}
+		}
+
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("the cluster has no %s", selector)
+		}
+
+		return selected, nil
+	}
+
+	node, err := findNode(nodes, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return []types.Node{node}, nil
+}
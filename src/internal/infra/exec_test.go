@@ -0,0 +1,76 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+func TestSelectNodes(t *testing.T) {
+	nodes := []types.Node{
+		{Name: "server-0", Role: roleServer},
+		{Name: "agent-0", Role: roleAgent},
+		{Name: "agent-1", Role: roleAgent},
+	}
+
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{selector: "all", expected: []string{"server-0", "agent-0", "agent-1"}},
+		{selector: "servers", expected: []string{"server-0"}},
+		{selector: "agents", expected: []string{"agent-0", "agent-1"}},
+		{selector: "agent-1", expected: []string{"agent-1"}},
+	}
+
+	for _, test := range tests {
+		selected, err := selectNodes(nodes, test.selector)
+		if err != nil {
+			t.Errorf("expected: no error | got: %s", err)
+			continue
+		}
+
+		var names []string
+		for _, node := range selected {
+			names = append(names, node.Name)
+		}
+
+		if len(names) != len(test.expected) {
+			t.Errorf("expected: %v | got: %v", test.expected, names)
+			continue
+		}
+
+		for i := range names {
+			if names[i] != test.expected[i] {
+				t.Errorf("expected: %v | got: %v", test.expected, names)
+				break
+			}
+		}
+	}
+
+	if _, err := selectNodes(nodes, "agent-2"); err == nil {
+		t.Errorf("expected: error for unknown node | got: nil")
+	}
+
+	if _, err := selectNodes(nodes[:1], "agents"); err == nil {
+		t.Errorf("expected: error for a cluster without agents | got: nil")
+	}
+}
+
+func TestRemoteCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"uptime"}, expected: `uptime`},
+		{args: []string{"sudo", "systemctl", "restart", "k3s-agent"}, expected: `sudo systemctl restart k3s-agent`},
+		{args: []string{"ls /var/lib/rancher | wc -l"}, expected: `ls /var/lib/rancher | wc -l`},
+		{args: []string{"echo", "'my dir'", "$HOME"}, expected: `echo 'my dir' $HOME`},
+	}
+
+	for _, test := range tests {
+		if command := remoteCommand(test.args); command != test.expected {
+			t.Errorf("expected: %s | got: %s", test.expected, command)
+		}
+	}
+}
@@ -1,106 +1,123 @@
 package infra
 
 import (
-	"os"
-	"path"
-	"path/filepath"
-	"strings"
+	"fmt"
+	"io"
 
 	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
-	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
 )
 
-// InstallK3s installs k3s on an ec2 instance via SSH
-func InstallK3s(region string) error {
-	sshClient, err := ssh.ConfigureSSHClient(region)
+// InstallK3s installs k3s on an ec2 instance via SSH.
+// It is safe to run repeatedly: an existing install is upgraded if a different version is pinned,
+// reconfigured if its configuration files changed, and otherwise left alone
+func InstallK3s(config types.ConfigFile, connections *ssh.Manager) error {
+	sshClient, err := connections.Server()
 	if err != nil {
 		return err
 	}
 
-	// Close the underlying network connection
-	defer sshClient.Close()
-
-	ip, err := utils.GetInstanceIp(region)
+	server, err := connections.ServerNode()
 	if err != nil {
 		return err
 	}
 
-	installK3sCommand := "curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC='--tls-san=" + ip + "' sh -s - --disable traefik"
+	var snapshotBucket string
+	if config.Snapshots.S3 {
+		if snapshotBucket, err = snapshotBucketName(config.Region); err != nil {
+			return err
+		}
+	}
 
-	if _, err = sshClient.Execute(installK3sCommand); err != nil {
-		return err
+	// A token set in the k3s config is used as is
+	if _, ok := config.K3s.Config["token"]; !ok {
+		// Clusters created before tokens were stored in the stack keep the token k3s generated
+		if config.K3s.Token == "" {
+			if config.K3s.Token, err = serverToken(sshClient); err != nil {
+				return err
+			}
+		}
+
+		if snapshotBucket != "" && config.K3s.Token != "" {
+			if err := storeSnapshotToken(config, snapshotBucket); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
-}
+	files, err := k3sConfigFiles(config, server.IP, snapshotBucket)
+	if err != nil {
+		return err
+	}
 
-// GetKubeconfig fetches the kubeconfig from the remote host
-// and writes it to working directory on local disk
-func GetKubeconfig(region string) error {
-	sshClient, err := ssh.ConfigureSSHClient(region)
+	stale, err := staleRegistryFiles(sshClient, files)
 	if err != nil {
 		return err
 	}
 
-	getConfigCommand := "sudo cat /etc/rancher/k3s/k3s.yaml"
+	files = append(files, stale...)
 
-	output, err := sshClient.ExecuteOutput(getConfigCommand, false)
+	installedVersion, err := installedK3sVersion(sshClient)
 	if err != nil {
 		return err
 	}
 
-	ip, err := utils.GetInstanceIp(region)
+	changed, err := changedFiles(sshClient, files)
 	if err != nil {
 		return err
-
 	}
 
-	kubeconfig := editKubeconfig(string(output.StdOut), ip)
-
-	if err := writeKubeconfig([]byte(kubeconfig)); err != nil {
+	action, err := chooseAction(installedVersion, config.K3s.Version, len(changed) > 0)
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	switch action {
+	case actionInstall:
+		fmt.Fprintln(config.ProgressWriter(), "k3s is not installed, installing")
+	case actionUpgrade:
+		fmt.Fprintf(config.ProgressWriter(), "k3s %s is installed, upgrading to %s\n", installedVersion, config.K3s.Version)
+	case actionReconfigure:
+		fmt.Fprintf(config.ProgressWriter(), "k3s %s is installed but its configuration changed, reconfiguring\n", installedVersion)
+	case actionSkip:
+		fmt.Fprintf(config.ProgressWriter(), "k3s %s is already installed and configured, skipping install\n", installedVersion)
+		return nil
+	}
 
-// Edit kubeconfig file with public IP of ec2 instance to connect to
-func editKubeconfig(kubeconfig string, ip string) []byte {
-	kubeconfigChanges := strings.NewReplacer(
-		"127.0.0.1", ip,
-		"localhost", ip,
-	)
+	// k3s reads its configuration files on startup, so they must be in place before it (re)starts
+	if err := writeRemoteFiles(sshClient, changed, config.ProgressWriter()); err != nil {
+		return err
+	}
 
-	return []byte(kubeconfigChanges.Replace(kubeconfig))
-}
+	if action == actionReconfigure {
+		_, err = sshClient.Execute("sudo systemctl restart k3s")
+		return err
+	}
 
-// Write kubeconfig file to disk
-func writeKubeconfig(data []byte) error {
-	absPath, err := getAbsolutePath()
+	installK3sCommand, err := k3sInstallCommand(sshClient, config.K3s, config.ProgressWriter())
 	if err != nil {
 		return err
 	}
 
-	filePath := path.Join(absPath, "kubeconfig")
-
-	if err := os.WriteFile(filePath, []byte(data), 0600); err != nil {
+	if _, err = sshClient.Execute(installK3sCommand); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// Get the absolute path of the current working directory
-func getAbsolutePath() (string, error) {
-	workingDir, err := os.Getwd()
+// k3sInstallCommand uploads the verified k3s installer and binary for the configured
+// version to the ec2 instance and returns the command that runs the installer
+func k3sInstallCommand(sshClient *ssh.SSHClient, k3s types.K3s, progress io.Writer) (string, error) {
+	version, err := resolveK3sVersion(k3s.Version)
 	if err != nil {
 		return "", err
 	}
 
-	absPath, err := filepath.Abs(workingDir)
-	if err != nil {
+	// Upload everything the install needs so the instance never downloads anything itself
+	if err := uploadK3sArtifacts(sshClient, version, k3s.Airgap, progress); err != nil {
 		return "", err
 	}
 
-	return absPath, nil
+	return "INSTALL_K3S_SKIP_DOWNLOAD=true " + remoteInstallScript, nil
 }
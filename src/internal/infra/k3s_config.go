@@ -0,0 +1,98 @@
+package infra
+
+import (
+	"fmt"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"gopkg.in/yaml.v2"
+)
+
+const k3sConfigPath string = "/etc/rancher/k3s/config.yaml"
+
+// renderK3sConfig merges the settings computed by ec2-k3s into the
+// user provided k3s configuration and returns it as YAML.
+// The snapshot bucket is only used when S3 snapshots are enabled
+func renderK3sConfig(config types.ConfigFile, ip, snapshotBucket string) ([]byte, error) {
+	k3sConfig, err := buildK3sConfig(config, ip, snapshotBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(k3sConfig)
+}
+
+// buildK3sConfig returns the k3s configuration rendered by renderK3sConfig
+func buildK3sConfig(config types.ConfigFile, ip, snapshotBucket string) (map[string]interface{}, error) {
+	// Copy the user config so the merge never modifies the config file contents
+	k3sConfig := make(map[string]interface{}, len(config.K3s.Config))
+	for key, value := range config.K3s.Config {
+		k3sConfig[key] = value
+	}
+
+	// The public IP must be a SAN on the API server certificate to connect from the workstation
+	if err := appendListValue(k3sConfig, "tls-san", ip); err != nil {
+		return nil, err
+	}
+
+	if err := appendListValue(k3sConfig, "disable", disabledComponents(config.Components)...); err != nil {
+		return nil, err
+	}
+
+	// Snapshots can only be restored by a cluster with the token of the cluster that took them,
+	// so every cluster is given the same token unless one is configured
+	if _, ok := k3sConfig["token"]; !ok && config.K3s.Token != "" {
+		k3sConfig["token"] = config.K3s.Token
+	}
+
+	// Snapshots are taken by embedded etcd, which replaces the default sqlite datastore
+	if config.Snapshots.Enabled || config.Snapshots.S3 {
+		k3sConfig["cluster-init"] = true
+	}
+
+	if config.Snapshots.S3 {
+		k3sConfig["etcd-s3"] = true
+		k3sConfig["etcd-s3-bucket"] = snapshotBucket
+		k3sConfig["etcd-s3-region"] = config.Region
+		k3sConfig["etcd-s3-folder"] = snapshotFolder(config)
+	}
+
+	return k3sConfig, nil
+}
+
+// appendListValue adds values to a k3s flag that may be repeated,
+// accepting either a single value or a list for the existing setting
+func appendListValue(k3sConfig map[string]interface{}, key string, values ...string) error {
+	var list []interface{}
+
+	switch existing := k3sConfig[key].(type) {
+	case nil:
+	case string:
+		list = append(list, existing)
+	case []interface{}:
+		list = append(list, existing...)
+	default:
+		return fmt.Errorf("k3s config %q must be a string or a list, got %T", key, existing)
+	}
+
+	for _, value := range values {
+		if !containsValue(list, value) {
+			list = append(list, value)
+		}
+	}
+
+	if len(list) > 0 {
+		k3sConfig[key] = list
+	}
+
+	return nil
+}
+
+func containsValue(list []interface{}, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+package infra
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"gopkg.in/yaml.v2"
+)
+
+// TestRenderK3sConfig tests that computed settings are merged into the user provided k3s config
+func TestRenderK3sConfig(t *testing.T) {
+	userConfig := map[string]interface{}{
+		"tls-san":      "k3s.example.com",
+		"cluster-cidr": "10.42.0.0/16",
+		"node-label":   []interface{}{"env=dev"},
+	}
+
+	config := types.ConfigFile{
+		K3s: types.K3s{Config: userConfig},
+		Components: map[string]bool{
+			"traefik":        true,
+			"metrics-server": false,
+		},
+	}
+
+	data, err := renderK3sConfig(config, "203.0.113.10", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"tls-san":      []interface{}{"k3s.example.com", "203.0.113.10"},
+		"cluster-cidr": "10.42.0.0/16",
+		"node-label":   []interface{}{"env=dev"},
+		"disable":      []interface{}{"metrics-server"},
+	}
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected: %v | got: %v", expected, got)
+	}
+
+	if _, ok := userConfig["disable"]; ok {
+		t.Error("expected the user config to be left unmodified")
+	}
+}
+
+// TestRenderK3sConfigInvalidListValue tests that a repeatable flag with an unexpected type is rejected
+func TestRenderK3sConfigInvalidListValue(t *testing.T) {
+	config := types.ConfigFile{
+		K3s: types.K3s{
+			Config: map[string]interface{}{"tls-san": 42},
+		},
+	}
+
+	if _, err := renderK3sConfig(config, "203.0.113.10", ""); err == nil {
+		t.Error("expected an error for a tls-san value that is not a string or list")
+	}
+}
+
+// TestRenderK3sConfigToken tests that the cluster's token is rendered unless one is configured
+func TestRenderK3sConfigToken(t *testing.T) {
+	config := types.ConfigFile{
+		K3s: types.K3s{Token: "cluster-token"},
+	}
+
+	for _, configured := range []string{"", "configured-token"} {
+		if configured != "" {
+			config.K3s.Config = map[string]interface{}{"token": configured}
+		}
+
+		data, err := renderK3sConfig(config, "203.0.113.10", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "cluster-token"
+		if configured != "" {
+			expected = configured
+		}
+
+		if got["token"] != expected {
+			t.Errorf("expected: %s | got: %v", expected, got["token"])
+		}
+	}
+}
+
+// TestParseServerToken tests that the secret is read from the token k3s stores on a server
+func TestParseServerToken(t *testing.T) {
+	tests := map[string]string{
+		"K10e3c3a6b5e2c5ba0a3ee3e54d7f19bdf4e7bc7a8d1d5b4a8d7a0e9df8a6bd0b1c::server:0123456789abcdef\n": "0123456789abcdef",
+		"0123456789abcdef\n": "0123456789abcdef",
+		"":                   "",
+	}
+
+	for token, expected := range tests {
+		if got := parseServerToken(token); got != expected {
+			t.Errorf("expected: %s | got: %s", expected, got)
+		}
+	}
+}
+
+// TestClusterToken tests that the token of a previous run is reused and a new cluster gets a new token
+func TestClusterToken(t *testing.T) {
+	outputs := auto.OutputMap{
+		instanceIdOutput: {Value: "i-0123456789abcdef0"},
+		k3sTokenOutput:   {Value: "stored-token", Secret: true},
+	}
+
+	token, err := clusterToken(types.ConfigFile{}, "alice-dev", outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "stored-token" {
+		t.Errorf("expected: %s | got: %s", "stored-token", token)
+	}
+
+	// A cluster created before tokens were stored keeps the token k3s generated for it
+	delete(outputs, k3sTokenOutput)
+
+	if token, err = clusterToken(types.ConfigFile{}, "alice-dev", outputs); err != nil || token != "" {
+		t.Errorf("expected: no token | got: %q, %v", token, err)
+	}
+
+	first, err := clusterToken(types.ConfigFile{}, "alice-dev", auto.OutputMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := clusterToken(types.ConfigFile{}, "alice-dev", auto.OutputMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != 64 || first == second {
+		t.Errorf("expected: two distinct 64 character tokens | got: %s and %s", first, second)
+	}
+}
@@ -0,0 +1,355 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// apiServerPort is the port the k3s API server listens on
+const apiServerPort string = "6443"
+
+// URI schemes of kubeconfig destinations in AWS
+const (
+	ssmScheme            string = "ssm://"
+	secretsManagerScheme string = "secretsmanager://"
+)
+
+// GetKubeconfig fetches the kubeconfig from the remote host,
+// writes it to the configured destination and returns it.
+// It is also merged into the user's kubeconfig if requested
+func GetKubeconfig(config types.ConfigFile, opts types.KubeconfigOptions, connections *ssh.Manager) ([]byte, error) {
+	kubeconfig, err := fetchKubeconfig(config, connections)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeKubeconfig(kubeconfig, config.Region, opts, config.ProgressWriter()); err != nil {
+		return nil, err
+	}
+
+	if opts.Merge {
+		if err := mergeKubeconfig(kubeconfig, config.Cluster.Name, opts.SwitchContext, config.ProgressWriter()); err != nil {
+			return nil, err
+		}
+	}
+
+	return kubeconfig, nil
+}
+
+// fetchKubeconfig fetches the kubeconfig from the remote host
+// and points it at the public IP of the ec2 instance
+func fetchKubeconfig(config types.ConfigFile, connections *ssh.Manager) ([]byte, error) {
+	sshClient, err := connections.Server()
+	if err != nil {
+		return nil, err
+	}
+
+	output := bytes.Buffer{}
+	if err := sshClient.Download(context.Background(), "/etc/rancher/k3s/k3s.yaml", &output); err != nil {
+		return nil, err
+	}
+
+	server, err := connections.ServerNode()
+	if err != nil {
+		return nil, err
+	}
+
+	return editKubeconfig(output.Bytes(), server.IP, config.Cluster.Name)
+}
+
+// editKubeconfig points the kubeconfig's cluster at the ec2 instance and renames its
+// cluster, user and context, which k3s names "default", to the given name.
+// The embedded certificates are validated so a corrupted kubeconfig is never written
+func editKubeconfig(kubeconfig []byte, host string, name string) ([]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing kubeconfig: %w", err)
+	}
+
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no current context")
+	}
+
+	cluster, ok := config.Clusters[context.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no cluster named %q", context.Cluster)
+	}
+
+	authInfo, ok := config.AuthInfos[context.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no user named %q", context.AuthInfo)
+	}
+
+	serverURL, err := url.Parse(cluster.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing kubeconfig server URL: %w", err)
+	}
+
+	port := serverURL.Port()
+	if port == "" {
+		port = apiServerPort
+	}
+
+	// JoinHostPort brackets IPv6 addresses
+	serverURL.Host = net.JoinHostPort(host, port)
+	cluster.Server = serverURL.String()
+
+	if err := validateKubeconfigCerts(cluster, authInfo); err != nil {
+		return nil, err
+	}
+
+	edited := clientcmdapi.NewConfig()
+	edited.Clusters[name] = cluster
+	edited.AuthInfos[name] = authInfo
+	edited.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  name,
+		AuthInfo: name,
+	}
+	edited.CurrentContext = name
+
+	return clientcmd.Write(*edited)
+}
+
+// validateKubeconfigCerts checks that the embedded CA and client certificate parse
+func validateKubeconfigCerts(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo) error {
+	if len(cluster.CertificateAuthorityData) > 0 {
+		if !x509.NewCertPool().AppendCertsFromPEM(cluster.CertificateAuthorityData) {
+			return fmt.Errorf("kubeconfig certificate authority data is not a valid PEM certificate")
+		}
+	}
+
+	if len(authInfo.ClientCertificateData) > 0 || len(authInfo.ClientKeyData) > 0 {
+		if _, err := tls.X509KeyPair(authInfo.ClientCertificateData, authInfo.ClientKeyData); err != nil {
+			return fmt.Errorf("kubeconfig client certificate is invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeKubeconfig writes the kubeconfig to stdout, an SSM parameter, a Secrets Manager
+// secret or a file, which defaults to "kubeconfig" in the working directory.
+// Where it was written is reported to progress
+func writeKubeconfig(data []byte, region string, opts types.KubeconfigOptions, progress io.Writer) error {
+	switch {
+	case opts.Out == "-":
+		_, err := opts.Stdout.Write(data)
+		return err
+
+	case strings.HasPrefix(opts.Out, ssmScheme):
+		name := strings.TrimPrefix(opts.Out, ssmScheme)
+		if err := utils.PutSSMParameter(region, name, data); err != nil {
+			return fmt.Errorf("failed writing kubeconfig to SSM parameter %s: %w", name, err)
+		}
+
+		fmt.Fprintf(progress, "Wrote kubeconfig to SSM parameter %s\n", name)
+		return nil
+
+	case strings.HasPrefix(opts.Out, secretsManagerScheme):
+		name := strings.TrimPrefix(opts.Out, secretsManagerScheme)
+		if err := utils.PutSecret(region, name, data); err != nil {
+			return fmt.Errorf("failed writing kubeconfig to secret %s: %w", name, err)
+		}
+
+		fmt.Fprintf(progress, "Wrote kubeconfig to secret %s\n", name)
+		return nil
+	}
+
+	filePath := opts.Out
+	if filePath == "" {
+		absPath, err := getAbsolutePath()
+		if err != nil {
+			return err
+		}
+
+		filePath = path.Join(absPath, "kubeconfig")
+	}
+
+	// Leave an up to date kubeconfig untouched so re-running "up" does not disturb its readers
+	if existing, err := os.ReadFile(filePath); err == nil && bytes.Equal(existing, data) {
+		fmt.Fprintf(progress, "Kubeconfig at %s is up to date\n", filePath)
+		return nil
+	}
+
+	if err := writeFileAtomic(filePath, data); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "Wrote kubeconfig to %s\n", filePath)
+
+	return nil
+}
+
+// writeFileAtomic writes a file readable only by the current user. The data is written
+// to a temporary file that replaces the file, so readers never see a partial write
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if err := tmpFile.Chmod(0600); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filePath)
+}
+
+// mergeKubeconfig adds the cluster, user and context of a kubeconfig to the user's kubeconfig
+// under the given name, replacing any previous entries with that name
+func mergeKubeconfig(kubeconfig []byte, name string, switchContext bool, progress io.Writer) error {
+	newConfig, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	context, ok := newConfig.Contexts[newConfig.CurrentContext]
+	if !ok {
+		return fmt.Errorf("kubeconfig has no current context")
+	}
+
+	filePath := userKubeconfigPath()
+
+	userConfig, err := loadUserKubeconfig(filePath)
+	if err != nil {
+		return err
+	}
+
+	// Entries are stored under the cluster name so they do not collide with other clusters
+	userConfig.Clusters[name] = newConfig.Clusters[context.Cluster]
+	userConfig.AuthInfos[name] = newConfig.AuthInfos[context.AuthInfo]
+	userConfig.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  name,
+		AuthInfo: name,
+	}
+
+	if switchContext || userConfig.CurrentContext == "" {
+		userConfig.CurrentContext = name
+	}
+
+	if err := writeUserKubeconfig(userConfig, filePath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "Merged context %s into %s\n", name, filePath)
+
+	return nil
+}
+
+// RemoveKubeconfigContext removes the cluster's context, cluster and user
+// from the user's kubeconfig, if they were merged into it
+func RemoveKubeconfigContext(name string, progress io.Writer) error {
+	filePath := userKubeconfigPath()
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	userConfig, err := loadUserKubeconfig(filePath)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := userConfig.Contexts[name]; !ok {
+		return nil
+	}
+
+	delete(userConfig.Clusters, name)
+	delete(userConfig.AuthInfos, name)
+	delete(userConfig.Contexts, name)
+
+	if userConfig.CurrentContext == name {
+		userConfig.CurrentContext = ""
+	}
+
+	if err := writeUserKubeconfig(userConfig, filePath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "Removed context %s from %s\n", name, filePath)
+
+	return nil
+}
+
+// userKubeconfigPath returns the first file in $KUBECONFIG, or ~/.kube/config if it is not set
+func userKubeconfigPath() string {
+	for _, filePath := range filepath.SplitList(os.Getenv(clientcmd.RecommendedConfigPathEnvVar)) {
+		if filePath != "" {
+			return filePath
+		}
+	}
+
+	return clientcmd.RecommendedHomeFile
+}
+
+// loadUserKubeconfig loads the user's kubeconfig, or an empty config if it does not exist yet
+func loadUserKubeconfig(filePath string) (*clientcmdapi.Config, error) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return clientcmdapi.NewConfig(), nil
+	}
+
+	return clientcmd.LoadFromFile(filePath)
+}
+
+// writeUserKubeconfig atomically writes the user's kubeconfig, creating its directory if needed
+func writeUserKubeconfig(config *clientcmdapi.Config, filePath string) error {
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filePath, data)
+}
+
+// Get the absolute path of the current working directory
+func getAbsolutePath() (string, error) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	absPath, err := filepath.Abs(workingDir)
+	if err != nil {
+		return "", err
+	}
+
+	return absPath, nil
+}
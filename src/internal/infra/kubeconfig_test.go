@@ -0,0 +1,170 @@
+package infra
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const k3sKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://203.0.113.10:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+current-context: default
+users:
+- name: default
+  user:
+    token: secret
+`
+
+// TestMergeKubeconfig tests that the cluster is merged into and removed from the user's kubeconfig
+func TestMergeKubeconfig(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config")
+	t.Setenv(clientcmd.RecommendedConfigPathEnvVar, filePath)
+
+	name := "alice-dev"
+
+	if err := mergeKubeconfig([]byte(k3sKubeconfig), name, false, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := clientcmd.LoadFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context, ok := merged.Contexts[name]
+	if !ok || context.Cluster != name || context.AuthInfo != name {
+		t.Errorf("expected context %s to reference cluster and user %s | got: %+v", name, name, context)
+	}
+
+	if server := merged.Clusters[name].Server; server != "https://203.0.113.10:6443" {
+		t.Errorf("expected: %s | got: %s", "https://203.0.113.10:6443", server)
+	}
+
+	// The first merged context becomes the current context even without switching
+	if merged.CurrentContext != name {
+		t.Errorf("expected: %s | got: %s", name, merged.CurrentContext)
+	}
+
+	if err := RemoveKubeconfigContext(name, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := clientcmd.LoadFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed.Contexts) != 0 || len(removed.Clusters) != 0 || len(removed.AuthInfos) != 0 || removed.CurrentContext != "" {
+		t.Errorf("expected the cluster to be removed from the kubeconfig | got: %+v", removed)
+	}
+}
+
+// TestEditKubeconfig tests that only the server URL is rewritten and the entries are renamed
+func TestEditKubeconfig(t *testing.T) {
+	certPEM, keyPEM := generateTestCertificate(t)
+
+	k3sConfig := clientcmdapi.NewConfig()
+	k3sConfig.Clusters["default"] = &clientcmdapi.Cluster{
+		Server:                   "https://127.0.0.1:6443",
+		CertificateAuthorityData: certPEM,
+	}
+	k3sConfig.AuthInfos["default"] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: certPEM,
+		ClientKeyData:         keyPEM,
+		// A field containing the loopback address must be left alone
+		Username: "admin@127.0.0.1",
+	}
+	k3sConfig.Contexts["default"] = &clientcmdapi.Context{Cluster: "default", AuthInfo: "default"}
+	k3sConfig.CurrentContext = "default"
+
+	kubeconfig, err := clientcmd.Write(*k3sConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := editKubeconfig(kubeconfig, "2001:db8::10", "test-dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if edited.CurrentContext != "test-dev" {
+		t.Errorf("expected: %s | got: %s", "test-dev", edited.CurrentContext)
+	}
+
+	expectedServer := "https://[2001:db8::10]:6443"
+	if server := edited.Clusters["test-dev"].Server; server != expectedServer {
+		t.Errorf("expected: %s | got: %s", expectedServer, server)
+	}
+
+	if username := edited.AuthInfos["test-dev"].Username; username != "admin@127.0.0.1" {
+		t.Errorf("expected: %s | got: %s", "admin@127.0.0.1", username)
+	}
+
+	// A client key that does not match the certificate must be rejected
+	_, otherKeyPEM := generateTestCertificate(t)
+	k3sConfig.AuthInfos["default"].ClientKeyData = otherKeyPEM
+
+	kubeconfig, err = clientcmd.Write(*k3sConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := editKubeconfig(kubeconfig, "203.0.113.10", "test-dev"); err == nil {
+		t.Error("expected an error for a client key that does not match the certificate")
+	}
+}
+
+// generateTestCertificate returns a PEM encoded self-signed certificate and its private key
+func generateTestCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM
+}
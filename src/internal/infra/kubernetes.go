@@ -0,0 +1,162 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	pollInterval = 3 * time.Second
+
+	// managedByLabel marks Kubernetes objects created by ec2-k3s
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// newKubeClient creates a Kubernetes client from kubeconfig file contents
+func newKubeClient(kubeconfig []byte) (*kubernetes.Clientset, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// cordonNode marks a node as unschedulable, or schedulable again when unschedulable is false
+func cordonNode(ctx context.Context, clientset *kubernetes.Clientset, nodeName string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+
+	_, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, k8stypes.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+
+	return err
+}
+
+// drainNode evicts every pod on a node except DaemonSet and mirror pods,
+// and waits for the evicted pods to be deleted
+func drainNode(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) error {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return err
+	}
+
+	var evicted []corev1.Pod
+	for _, pod := range pods.Items {
+		if !shouldEvict(pod) {
+			continue
+		}
+
+		if err := evictPod(ctx, clientset, pod); err != nil {
+			return err
+		}
+
+		evicted = append(evicted, pod)
+	}
+
+	for _, pod := range evicted {
+		if err := waitPodDeleted(ctx, clientset, pod); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// shouldEvict skips pods that a drain cannot or does not need to remove
+func shouldEvict(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+
+	// Mirror pods are managed by the kubelet from static manifests
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+
+	// DaemonSet pods would be recreated on the node straight away
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// evictPod evicts a pod, retrying while a PodDisruptionBudget blocks the eviction
+func evictPod(ctx context.Context, clientset *kubernetes.Clientset, pod corev1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+
+	for {
+		err := clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		if !apierrors.IsTooManyRequests(err) {
+			return fmt.Errorf("failed evicting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out evicting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func waitPodDeleted(ctx context.Context, clientset *kubernetes.Clientset, pod corev1.Pod) error {
+	for {
+		current, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for pod %s/%s to be deleted", pod.Namespace, pod.Name)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// waitNodeVersion waits for a node to be Ready and report the given kubelet version.
+// Errors are retried because the API server restarts while a server node is upgraded
+func waitNodeVersion(ctx context.Context, clientset *kubernetes.Clientset, nodeName, version string) error {
+	var lastState string
+
+	for {
+		node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			lastState = err.Error()
+		} else {
+			kubeletVersion := node.Status.NodeInfo.KubeletVersion
+			if kubeletVersion == version && isNodeReady(*node) {
+				return nil
+			}
+
+			lastState = fmt.Sprintf("kubelet version %s, ready: %t", kubeletVersion, isNodeReady(*node))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for node %s to be Ready at %s (%s)", nodeName, version, lastState)
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
@@ -19,29 +20,48 @@ const (
 )
 
 // Up provisions AWS infrastructure
-func Up(region, instanceType string) error {
-	pulumiStack, ctx := configurePulumi(region, instanceType)
+func Up(config types.ConfigFile, kubeconfigOpts types.KubeconfigOptions) error {
+	pulumiStack, ctx := configurePulumi(config)
 
-	// Wire up our update to stream progress to stdout
-	stdoutStreamer := optup.ProgressStreams(os.Stdout)
+	// Wire up our update to stream progress to stdout, or stderr when stdout is the kubeconfig
+	stdoutStreamer := optup.ProgressStreams(config.ProgressWriter())
 
 	// Run the update to deploy our infrastructure
-	if _, err := pulumiStack.Up(ctx, stdoutStreamer); err != nil {
+	result, err := pulumiStack.Up(ctx, stdoutStreamer)
+	if err != nil {
+		return err
+	}
+
+	// Load the cluster's nodes, and the key pair generated for the cluster, from the stack
+	config, err = applyStackOutputs(config, result.Outputs)
+	if err != nil {
 		return err
 	}
 
+	server := config.Cluster.Nodes[0]
+
 	// Wait for ec2 instance to be ready
-	if err := WaitInstanceReady(region); err != nil {
+	if err := WaitInstanceReady(config.Region, server.InstanceID, config.ProgressWriter()); err != nil {
 		return err
 	}
 
+	// Share one SSH connection to the ec2 instance between the remaining steps
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
 	// Install k3s on ec2 instance
-	if err := InstallK3s(region); err != nil {
+	if err := InstallK3s(config, connections); err != nil {
 		return err
 	}
 
 	// Copy kubeconfig from remote host to local machine
-	if err := GetKubeconfig(region); err != nil {
+	kubeconfig, err := GetKubeconfig(config, kubeconfigOpts, connections)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the nodes and system workloads to be ready to use
+	if err := WaitClusterReady(kubeconfig, config); err != nil {
 		return err
 	}
 
@@ -49,11 +69,22 @@ func Up(region, instanceType string) error {
 }
 
 // Down tears down AWS infrastructure
-func Down(region, instanceType string) error {
-	pulumiStack, ctx := configurePulumi(region, instanceType)
+func Down(config types.ConfigFile) error {
+	pulumiStack, ctx := configurePulumi(config)
+
+	// Read the cluster's name before the stack that stores it is removed
+	outputs, err := pulumiStack.Outputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	name, err := clusterName(config, outputs)
+	if err != nil {
+		return err
+	}
 
 	// Wire up our destroy to stream progress to stdout
-	stdoutStreamer := optdestroy.ProgressStreams(os.Stdout)
+	stdoutStreamer := optdestroy.ProgressStreams(config.ProgressWriter())
 
 	// Destroy resources in the stack
 	if _, err := pulumiStack.Destroy(ctx, stdoutStreamer); err != nil {
@@ -67,42 +98,81 @@ func Down(region, instanceType string) error {
 		return err
 	}
 
-	fmt.Printf("Stack '%s' has been removed\n", stackName)
+	fmt.Fprintf(config.ProgressWriter(), "Stack '%s' has been removed\n", stackName)
+
+	// Remove the cluster from the user's kubeconfig now that it no longer exists
+	if err := RemoveKubeconfigContext(name, config.ProgressWriter()); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func deployInfra(instanceType string) pulumi.RunFunc {
+// deployInfra returns the Pulumi program. The inputs are read when the program runs,
+// so they can be filled in after the stack has been selected
+func deployInfra(config types.ConfigFile, inputs *stackInputs) pulumi.RunFunc {
 	deployFunc := func(ctx *pulumi.Context) error {
 		// Create SSH keypair in AWS
-		if _, err := CreateSSHKeyPair(ctx); err != nil {
+		var keyInfra *types.Infrastructure
+		var err error
+		if config.SSH.Ephemeral {
+			keyInfra, err = CreateEphemeralSSHKeyPair(ctx, inputs.clusterName, inputs.sshPrivateKey)
+		} else {
+			keyInfra, err = CreateSSHKeyPair(ctx, inputs.clusterName, config.SSH)
+		}
+
+		if err != nil {
 			return err
 		}
 
+		// Create S3 bucket for etcd snapshots and allow the ec2 instance to access it
+		var instanceProfile pulumi.StringInput
+		if config.Snapshots.S3 {
+			snapshotInfra, err := CreateSnapshotBucket(ctx, config.Region)
+			if err != nil {
+				return err
+			}
+
+			instanceProfile = snapshotInfra.InstanceProfile.Name
+			ctx.Export("Snapshot Bucket", snapshotInfra.SnapshotBucket.Bucket)
+		}
+
 		// Create ec2 instance and security group in AWS
-		infra, err := CreateInstance(ctx, instanceType)
+		infra, err := CreateInstance(ctx, inputs.clusterName, config.InstanceType, keyInfra.Keypair.KeyName, instanceProfile, config.ProgressWriter())
 		if err != nil {
 			return err
 		}
 
 		// Print outputs to stdout
-		ctx.Export("Instance ID", infra.Server.ID())
-		ctx.Export("Public IP Address", infra.Server.PublicIp)
+		ctx.Export(clusterNameOutput, pulumi.String(inputs.clusterName))
+		ctx.Export(instanceIdOutput, infra.Server.ID())
+		ctx.Export(publicIpOutput, infra.Server.PublicIp)
 		ctx.Export("Hostname", infra.Server.PublicDns)
 		ctx.Export("Instance Type", infra.Server.InstanceType)
 		ctx.Export("AMI ID", infra.Server.Ami)
 		ctx.Export("Instance Tags", infra.Server.Tags)
 
+		if inputs.k3sToken != "" {
+			ctx.Export(k3sTokenOutput, pulumi.ToSecret(pulumi.String(inputs.k3sToken)))
+		}
+
+		// Pin the instance's host keys in the stack so SSH connections to it are verified from any machine
+		ctx.Export(hostKeysOutput, infra.Server.ID().ApplyT(func(id pulumi.ID) (map[string]string, error) {
+			return pinHostKeys(config.Region, string(id), inputs.hostKeys, config.ProgressWriter())
+		}).(pulumi.StringMapOutput))
+
 		return nil
 	}
 
 	return deployFunc
 }
 
-func configurePulumi(region, instanceType string) (auto.Stack, context.Context) {
+func configurePulumi(config types.ConfigFile) (auto.Stack, context.Context) {
 	ctx := context.Background()
 
-	stack, err := auto.UpsertStackInlineSource(ctx, stackName, projectName, deployInfra(instanceType))
+	inputs := &stackInputs{}
+
+	stack, err := auto.UpsertStackInlineSource(ctx, stackName, projectName, deployInfra(config, inputs))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -115,7 +185,7 @@ func configurePulumi(region, instanceType string) (auto.Stack, context.Context)
 	}
 
 	// Set stack configuration specifying the AWS region to deploy
-	if err := stack.SetConfig(ctx, "aws:region", auto.ConfigValue{Value: region}); err != nil {
+	if err := stack.SetConfig(ctx, "aws:region", auto.ConfigValue{Value: config.Region}); err != nil {
 		log.Fatal(err)
 	}
 
@@ -124,5 +194,29 @@ func configurePulumi(region, instanceType string) (auto.Stack, context.Context)
 		log.Fatal(err)
 	}
 
+	// Keep the name, key pair and pinned host keys of an existing cluster
+	outputs, err := stack.Outputs(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if inputs.clusterName, err = clusterName(config, outputs); err != nil {
+		log.Fatal(err)
+	}
+
+	inputs.hostKeys = stackHostKeys(outputs)
+
+	// Generate the cluster's k3s token, or reuse the token of a previous run or of the snapshots in S3
+	if inputs.k3sToken, err = clusterToken(config, inputs.clusterName, outputs); err != nil {
+		log.Fatal(err)
+	}
+
+	// Generate the cluster's SSH key pair, or reuse the one generated by a previous run
+	if config.SSH.Ephemeral {
+		if inputs.sshPrivateKey, err = ephemeralSSHKey(outputs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	return stack, ctx
 }
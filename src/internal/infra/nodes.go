@@ -0,0 +1,41 @@
+package infra
+
+import (
+	"fmt"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+const (
+	roleServer string = "server"
+	roleAgent  string = "agent"
+)
+
+// GetNodes returns the nodes of the cluster loaded from the Pulumi stack, servers first and then agents
+func GetNodes(config types.ConfigFile) ([]types.Node, error) {
+	if len(config.Cluster.Nodes) == 0 {
+		return nil, fmt.Errorf("the cluster has not been loaded from stack %s", stackName)
+	}
+
+	return config.Cluster.Nodes, nil
+}
+
+// stackNodes returns the nodes described by the stack outputs.
+// The cluster currently consists of a single server
+func stackNodes(outputs auto.OutputMap) []types.Node {
+	instanceId := stringOutput(outputs, instanceIdOutput)
+	if instanceId == "" {
+		return nil
+	}
+
+	return []types.Node{
+		{
+			Name:       roleServer + "-0",
+			Role:       roleServer,
+			InstanceID: instanceId,
+			IP:         stringOutput(outputs, publicIpOutput),
+			HostKeys:   []byte(stackHostKeys(outputs)[instanceId]),
+		},
+	}
+}
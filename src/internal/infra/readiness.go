@@ -0,0 +1,222 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const defaultReadinessTimeout = 5 * time.Minute
+
+// componentDeployments maps packaged components to the kube-system deployment that runs them
+var componentDeployments = map[string]string{
+	"coredns":        "coredns",
+	"local-storage":  "local-path-provisioner",
+	"metrics-server": "metrics-server",
+	"traefik":        "traefik",
+}
+
+// readinessCheck reports whether one part of the cluster is ready, and why
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context, clientset *kubernetes.Clientset) (bool, string, error)
+}
+
+type readinessResult struct {
+	name   string
+	ready  bool
+	detail string
+}
+
+// WaitClusterReady waits for the API server to report ready, every node to be Ready
+// and every kube-system deployment to be available, then prints a summary of each check
+func WaitClusterReady(kubeconfig []byte, config types.ConfigFile) error {
+	clientset, err := newKubeClient(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	timeout := config.ReadinessTimeout
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	effective, err := EffectiveComponents(config)
+	if err != nil {
+		return err
+	}
+
+	checks := []readinessCheck{
+		{name: "api-server", check: checkAPIServerReady},
+		{name: "nodes", check: checkNodesReady},
+		{name: "deployments", check: checkDeploymentsAvailable(expectedDeployments(effective))},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s := spinner.New(spinner.CharSets[36], 1000*time.Millisecond, spinner.WithWriter(config.ProgressWriter()))
+	s.Start()
+
+	fmt.Fprintln(config.ProgressWriter(), "Waiting for Kubernetes to be ready...")
+
+	for {
+		results, ready := runReadinessChecks(ctx, clientset, checks)
+
+		if ready {
+			s.Stop()
+			printReadinessSummary(config.ProgressWriter(), results)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Stop()
+			printReadinessSummary(config.ProgressWriter(), results)
+			return fmt.Errorf("timed out after %s waiting for the cluster to be ready", timeout)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// runReadinessChecks runs every check once and reports whether all of them passed.
+// Errors are recorded as a failed check because the API server may not be reachable yet
+func runReadinessChecks(ctx context.Context, clientset *kubernetes.Clientset, checks []readinessCheck) ([]readinessResult, bool) {
+	results := make([]readinessResult, 0, len(checks))
+	allReady := true
+
+	for _, c := range checks {
+		ready, detail, err := c.check(ctx, clientset)
+		if err != nil {
+			ready, detail = false, err.Error()
+		}
+
+		if !ready {
+			allReady = false
+		}
+
+		results = append(results, readinessResult{name: c.name, ready: ready, detail: detail})
+	}
+
+	return results, allReady
+}
+
+func printReadinessSummary(progress io.Writer, results []readinessResult) {
+	w := tabwriter.NewWriter(progress, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Readiness summary:")
+
+	for _, result := range results {
+		state := "not ready"
+		if result.ready {
+			state = "ready"
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", result.name, state, result.detail)
+	}
+
+	w.Flush()
+}
+
+func checkAPIServerReady(ctx context.Context, clientset *kubernetes.Clientset) (bool, string, error) {
+	body, err := clientset.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+	if err != nil {
+		return false, "", err
+	}
+
+	return string(body) == "ok", fmt.Sprintf("/readyz returned %q", body), nil
+}
+
+func checkNodesReady(ctx context.Context, clientset *kubernetes.Clientset) (bool, string, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	ready := 0
+	for _, node := range nodes.Items {
+		if isNodeReady(node) {
+			ready++
+		}
+	}
+
+	detail := fmt.Sprintf("%d/%d nodes Ready", ready, len(nodes.Items))
+
+	return len(nodes.Items) > 0 && ready == len(nodes.Items), detail, nil
+}
+
+// checkDeploymentsAvailable checks that every kube-system deployment is available,
+// including the deployments of enabled components that k3s may not have created yet
+func checkDeploymentsAvailable(expected []string) func(context.Context, *kubernetes.Clientset) (bool, string, error) {
+	return func(ctx context.Context, clientset *kubernetes.Clientset) (bool, string, error) {
+		deployments, err := clientset.AppsV1().Deployments(metav1.NamespaceSystem).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, "", err
+		}
+
+		found := make(map[string]bool, len(deployments.Items))
+		available := 0
+		for _, deployment := range deployments.Items {
+			found[deployment.Name] = true
+			if isDeploymentAvailable(deployment) {
+				available++
+			}
+		}
+
+		for _, name := range expected {
+			if !found[name] {
+				return false, fmt.Sprintf("waiting for deployment %s to be created", name), nil
+			}
+		}
+
+		detail := fmt.Sprintf("%d/%d kube-system deployments available", available, len(deployments.Items))
+
+		return available == len(deployments.Items), detail, nil
+	}
+}
+
+// expectedDeployments returns the kube-system deployments of the enabled packaged components
+func expectedDeployments(effective map[string]bool) []string {
+	var expected []string
+	for _, name := range PackagedComponents {
+		if deployment, ok := componentDeployments[name]; ok && effective[name] {
+			expected = append(expected, deployment)
+		}
+	}
+
+	return expected
+}
+
+func isNodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func isDeploymentAvailable(deployment appsv1.Deployment) bool {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable && condition.Status != corev1.ConditionTrue {
+			return false
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	return deployment.Status.AvailableReplicas >= replicas
+}
@@ -0,0 +1,198 @@
+package infra
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	registriesConfigPath string = "/etc/rancher/k3s/registries.yaml"
+	registryCertsDir     string = "/etc/rancher/k3s/certs"
+)
+
+// k3sRegistries is the registries.yaml format read by k3s
+type k3sRegistries struct {
+	Mirrors map[string]k3sRegistryMirror `yaml:"mirrors,omitempty"`
+	Configs map[string]k3sRegistryConfig `yaml:"configs,omitempty"`
+}
+
+type k3sRegistryMirror struct {
+	Endpoints []string          `yaml:"endpoint,omitempty"`
+	Rewrites  map[string]string `yaml:"rewrite,omitempty"`
+}
+
+type k3sRegistryConfig struct {
+	Auth *k3sRegistryAuth `yaml:"auth,omitempty"`
+	TLS  *k3sRegistryTLS  `yaml:"tls,omitempty"`
+}
+
+type k3sRegistryAuth struct {
+	Username      string `yaml:"username,omitempty"`
+	Password      string `yaml:"password,omitempty"`
+	Auth          string `yaml:"auth,omitempty"`
+	IdentityToken string `yaml:"identity_token,omitempty"`
+}
+
+type k3sRegistryTLS struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// registryFile is a local TLS file that is uploaded to the ec2 instance
+type registryFile struct {
+	localPath  string
+	remotePath string
+	mode       os.FileMode
+}
+
+// ValidateRegistries returns an error if a registry mirror has no endpoints
+// or a TLS file does not exist on the workstation
+func ValidateRegistries(registries types.Registries) error {
+	for name, mirror := range registries.Mirrors {
+		if len(mirror.Endpoints) == 0 {
+			return fmt.Errorf("registry mirror %q must have at least one endpoint", name)
+		}
+	}
+
+	for name, config := range registries.Configs {
+		for _, file := range []string{config.TLS.CAFile, config.TLS.CertFile, config.TLS.KeyFile} {
+			if file == "" {
+				continue
+			}
+
+			file, err := utils.ExpandHome(file)
+			if err != nil {
+				return err
+			}
+
+			if _, err := os.Stat(file); err != nil {
+				return fmt.Errorf("registry %q: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// renderRegistries returns the registries.yaml contents for k3s
+// and the TLS files it references that must be uploaded
+func renderRegistries(registries types.Registries) ([]byte, []registryFile, error) {
+	rendered := k3sRegistries{
+		Mirrors: make(map[string]k3sRegistryMirror, len(registries.Mirrors)),
+		Configs: make(map[string]k3sRegistryConfig, len(registries.Configs)),
+	}
+
+	for name, mirror := range registries.Mirrors {
+		rendered.Mirrors[name] = k3sRegistryMirror{
+			Endpoints: mirror.Endpoints,
+			Rewrites:  mirror.Rewrites,
+		}
+	}
+
+	var files []registryFile
+
+	// Sort the registry names so the files are always uploaded in the same order
+	names := make([]string, 0, len(registries.Configs))
+	for name := range registries.Configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		config := registries.Configs[name]
+		renderedConfig := k3sRegistryConfig{}
+
+		if config.Auth != (types.RegistryAuth{}) {
+			renderedConfig.Auth = &k3sRegistryAuth{
+				Username:      config.Auth.Username,
+				Password:      config.Auth.Password,
+				Auth:          config.Auth.Auth,
+				IdentityToken: config.Auth.IdentityToken,
+			}
+		}
+
+		if config.TLS != (types.RegistryTLS{}) {
+			// Registry names may contain a port, which is not allowed in every directory name
+			certsDir := path.Join(registryCertsDir, strings.ReplaceAll(name, ":", "_"))
+			renderedTLS := &k3sRegistryTLS{
+				InsecureSkipVerify: config.TLS.InsecureSkipVerify,
+			}
+
+			tlsFiles := []struct {
+				localPath  string
+				remotePath *string
+				name       string
+				mode       os.FileMode
+			}{
+				{config.TLS.CAFile, &renderedTLS.CAFile, "ca.crt", 0644},
+				{config.TLS.CertFile, &renderedTLS.CertFile, "client.crt", 0644},
+				{config.TLS.KeyFile, &renderedTLS.KeyFile, "client.key", 0600},
+			}
+
+			for _, file := range tlsFiles {
+				if file.localPath == "" {
+					continue
+				}
+
+				localPath, err := utils.ExpandHome(file.localPath)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				*file.remotePath = path.Join(certsDir, file.name)
+				files = append(files, registryFile{localPath, *file.remotePath, file.mode})
+			}
+
+			renderedConfig.TLS = renderedTLS
+		}
+
+		rendered.Configs[name] = renderedConfig
+	}
+
+	data, err := yaml.Marshal(rendered)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, files, nil
+}
+
+// staleRegistryFiles returns removals for the TLS files on the ec2 instance that are no longer configured,
+// such as the files of a registry whose TLS config was removed
+func staleRegistryFiles(sshClient *ssh.SSHClient, files []remoteFile) ([]remoteFile, error) {
+	listCommand := "sudo find " + ssh.ShellQuote(registryCertsDir) + " -type f 2>/dev/null || true"
+
+	output, err := sshClient.ExecuteOutput(listCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	return staleFiles(strings.Fields(string(output.StdOut)), files), nil
+}
+
+// staleFiles returns removals for the remote paths that are not one of the desired files
+func staleFiles(remotePaths []string, files []remoteFile) []remoteFile {
+	desired := make(map[string]bool, len(files))
+	for _, file := range files {
+		desired[file.remotePath] = true
+	}
+
+	var stale []remoteFile
+	for _, remotePath := range remotePaths {
+		if !desired[remotePath] {
+			stale = append(stale, remoteFile{remotePath: remotePath})
+		}
+	}
+
+	return stale
+}
@@ -0,0 +1,105 @@
+package infra
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"gopkg.in/yaml.v2"
+)
+
+// TestRenderRegistries tests that registries are rendered in the k3s format
+// with TLS files pointing at their uploaded location
+func TestRenderRegistries(t *testing.T) {
+	registries := types.Registries{
+		Mirrors: map[string]types.RegistryMirror{
+			"docker.io": {Endpoints: []string{"https://mirror.example.com"}},
+		},
+		Configs: map[string]types.RegistryConfig{
+			"registry.example.com:5000": {
+				Auth: types.RegistryAuth{Username: "user", Password: "pass"},
+				TLS:  types.RegistryTLS{CAFile: "./ca.pem"},
+			},
+		},
+	}
+
+	data, files, err := renderRegistries(registries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := k3sRegistries{}
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if endpoint := got.Mirrors["docker.io"].Endpoints[0]; endpoint != "https://mirror.example.com" {
+		t.Errorf("expected: %s | got: %s", "https://mirror.example.com", endpoint)
+	}
+
+	config := got.Configs["registry.example.com:5000"]
+	if config.Auth == nil || config.Auth.Username != "user" {
+		t.Errorf("expected auth for user to be rendered | got: %+v", config.Auth)
+	}
+
+	expectedCAFile := "/etc/rancher/k3s/certs/registry.example.com_5000/ca.crt"
+	if config.TLS == nil || config.TLS.CAFile != expectedCAFile {
+		t.Errorf("expected: %s | got: %+v", expectedCAFile, config.TLS)
+	}
+
+	if len(files) != 1 || files[0].localPath != "./ca.pem" || files[0].remotePath != expectedCAFile {
+		t.Errorf("expected ./ca.pem to be uploaded to %s | got: %+v", expectedCAFile, files)
+	}
+}
+
+// TestRenderRegistriesExpandHome tests that TLS file paths starting with ~/ are read from the home directory
+func TestRenderRegistriesExpandHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	registries := types.Registries{
+		Configs: map[string]types.RegistryConfig{
+			"registry.example.com": {TLS: types.RegistryTLS{CAFile: "~/certs/ca.pem"}},
+		},
+	}
+
+	_, files, err := renderRegistries(registries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join(home, "certs", "ca.pem")
+	if len(files) != 1 || files[0].localPath != expected {
+		t.Errorf("expected: %s | got: %+v", expected, files)
+	}
+}
+
+// TestStaleFiles tests that TLS files of registries that are no longer configured are removed
+func TestStaleFiles(t *testing.T) {
+	files := []remoteFile{
+		{remotePath: "/etc/rancher/k3s/certs/registry.example.com/ca.crt", content: []byte("ca")},
+	}
+
+	remotePaths := []string{
+		"/etc/rancher/k3s/certs/registry.example.com/ca.crt",
+		"/etc/rancher/k3s/certs/registry.example.com/client.key",
+		"/etc/rancher/k3s/certs/old.example.com/ca.crt",
+	}
+
+	stale := staleFiles(remotePaths, files)
+
+	expected := []string{
+		"/etc/rancher/k3s/certs/registry.example.com/client.key",
+		"/etc/rancher/k3s/certs/old.example.com/ca.crt",
+	}
+
+	if len(stale) != len(expected) {
+		t.Fatalf("expected: %v | got: %+v", expected, stale)
+	}
+
+	for i, file := range stale {
+		if file.remotePath != expected[i] || file.content != nil {
+			t.Errorf("expected: removal of %s | got: %+v", expected[i], file)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package infra
+
+import (
+	"fmt"
+	"io"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+// Reset uninstalls k3s from every node and removes its state, then installs it again.
+// The AWS infrastructure is left untouched, so this is much faster than "down" and "up"
+func Reset(config types.ConfigFile, kubeconfigOpts types.KubeconfigOptions) error {
+	nodes, err := GetNodes(config)
+	if err != nil {
+		return err
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	for _, node := range nodes {
+		if err := resetNode(connections, node, config.ProgressWriter()); err != nil {
+			return fmt.Errorf("failed resetting node %s: %w", node.Name, err)
+		}
+	}
+
+	// Install k3s on ec2 instance
+	if err := InstallK3s(config, connections); err != nil {
+		return err
+	}
+
+	// Copy kubeconfig from remote host to local machine
+	kubeconfig, err := GetKubeconfig(config, kubeconfigOpts, connections)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the nodes and system workloads to be ready to use
+	return WaitClusterReady(kubeconfig, config)
+}
+
+// resetNode stops every k3s process on a node, runs the k3s uninstall script
+// and removes any state the uninstall leaves behind
+func resetNode(connections *ssh.Manager, node types.Node, progress io.Writer) error {
+	sshClient, err := connections.Connect(node)
+	if err != nil {
+		return err
+	}
+
+	uninstallScript := "/usr/local/bin/k3s-uninstall.sh"
+	if node.Role == roleAgent {
+		uninstallScript = "/usr/local/bin/k3s-agent-uninstall.sh"
+	}
+
+	fmt.Fprintf(progress, "Uninstalling k3s from node %s\n", node.Name)
+
+	resetCommands := []string{
+		// The scripts do not exist if a previous install failed or k3s was already removed
+		"if [ -x /usr/local/bin/k3s-killall.sh ]; then sudo /usr/local/bin/k3s-killall.sh; fi",
+		"if [ -x " + uninstallScript + " ]; then sudo " + uninstallScript + "; fi",
+		"sudo rm -rf /var/lib/rancher /etc/rancher",
+	}
+
+	for _, command := range resetCommands {
+		if _, err := sshClient.Execute(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
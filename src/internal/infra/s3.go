@@ -0,0 +1,98 @@
+package infra
+
+import (
+	"fmt"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// CreateSnapshotBucket creates an S3 bucket for etcd snapshots and an instance profile
+// that allows the ec2 instance to read and write them.
+// The bucket is retained when the stack is destroyed, and adopted by the next stack that needs it
+func CreateSnapshotBucket(ctx *pulumi.Context, region string) (*types.Infrastructure, error) {
+	bucketName, err := snapshotBucketName(region)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := utils.BucketExists(region, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []pulumi.ResourceOption{pulumi.RetainOnDelete(true)}
+	if exists {
+		opts = append(opts, pulumi.Import(pulumi.ID(bucketName)))
+	}
+
+	bucket, err := s3.NewBucketV2(ctx, "snapshot-bucket", &s3.BucketV2Args{
+		Bucket: pulumi.String(bucketName),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := iam.NewRole(ctx, "instance-role", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": {"Service": "ec2.amazonaws.com"},
+				"Action": "sts:AssumeRole"
+			}]
+		}`),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "snapshot-bucket-access", &iam.RolePolicyArgs{
+		Role: role.ID(),
+		Policy: pulumi.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{
+					"Effect": "Allow",
+					"Action": ["s3:ListBucket", "s3:GetBucketLocation"],
+					"Resource": "%[1]s"
+				},
+				{
+					"Effect": "Allow",
+					"Action": ["s3:GetObject", "s3:PutObject", "s3:DeleteObject"],
+					"Resource": "%[1]s/*"
+				}
+			]
+		}`, bucket.Arn),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	instanceProfile, err := iam.NewInstanceProfile(ctx, "instance-profile", &iam.InstanceProfileArgs{
+		Role: role.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Infrastructure{
+		SnapshotBucket:  bucket,
+		InstanceProfile: instanceProfile,
+	}, nil
+}
+
+// snapshotBucketName returns a bucket name that stays the same between stacks,
+// since bucket names are global and the snapshots must outlive the stack
+func snapshotBucketName(region string) (string, error) {
+	accountId, err := utils.GetAccountId(region)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-snapshots-%s-%s", projectName, accountId, region), nil
+}
@@ -0,0 +1,55 @@
+package infra
+
+import (
+	"fmt"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+// Shell opens an interactive shell on a node, or runs a command on it if one is given.
+// The node defaults to the first server
+func Shell(config types.ConfigFile, nodeName string, command []string) error {
+	nodes, err := GetNodes(config)
+	if err != nil {
+		return err
+	}
+
+	node, err := findNode(nodes, nodeName)
+	if err != nil {
+		return err
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	sshClient, err := connections.Connect(node)
+	if err != nil {
+		return err
+	}
+
+	if len(command) == 0 {
+		return sshClient.Shell()
+	}
+
+	return sshClient.Run(remoteCommand(command))
+}
+
+// findNode returns the node with the given name, or the first node if no name is given
+func findNode(nodes []types.Node, name string) (types.Node, error) {
+	if name == "" {
+		return nodes[0], nil
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Name == name {
+			return node, nil
+		}
+
+		names = append(names, node.Name)
+	}
+
+	return types.Node{}, fmt.Errorf("unknown node %q, must be one of: %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,102 @@
+package infra
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+const localSnapshotDir string = "/var/lib/rancher/k3s/server/db/snapshots"
+
+// SaveSnapshot takes an etcd snapshot on the server, optionally with a name prefix.
+// The snapshot is also uploaded to S3 when S3 snapshots are enabled
+func SaveSnapshot(config types.ConfigFile, name string) error {
+	if err := requireSnapshots(config); err != nil {
+		return err
+	}
+
+	saveCommand := "sudo k3s etcd-snapshot save"
+	if name != "" {
+		saveCommand += " --name " + ssh.ShellQuote(name)
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	return runServerCommand(connections, saveCommand)
+}
+
+// ListSnapshots lists the etcd snapshots on the server and in S3
+func ListSnapshots(config types.ConfigFile) error {
+	if err := requireSnapshots(config); err != nil {
+		return err
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	return runServerCommand(connections, "sudo k3s etcd-snapshot ls")
+}
+
+// RestoreSnapshot stops k3s, resets the cluster to the given etcd snapshot and starts k3s again.
+// A name without a path refers to a snapshot in S3 when S3 snapshots are enabled,
+// or otherwise to a snapshot in the local snapshot directory
+func RestoreSnapshot(config types.ConfigFile, name string) error {
+	if err := requireSnapshots(config); err != nil {
+		return err
+	}
+
+	restorePath := name
+	if !strings.Contains(name, "/") && !config.Snapshots.S3 {
+		restorePath = path.Join(localSnapshotDir, name)
+	}
+
+	fmt.Fprintf(config.ProgressWriter(), "Restoring snapshot %s\n", restorePath)
+
+	restoreCommands := []string{
+		"sudo systemctl stop k3s",
+		// k3s exits once the datastore has been reset to the snapshot
+		"sudo k3s server --cluster-reset --cluster-reset-restore-path=" + ssh.ShellQuote(restorePath),
+		"sudo systemctl start k3s",
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	for _, command := range restoreCommands {
+		if err := runServerCommand(connections, command); err != nil {
+			return err
+		}
+	}
+
+	kubeconfig, err := fetchKubeconfig(config, connections)
+	if err != nil {
+		return err
+	}
+
+	return WaitClusterReady(kubeconfig, config)
+}
+
+// requireSnapshots returns an error if the cluster does not run embedded etcd, which snapshots need
+func requireSnapshots(config types.ConfigFile) error {
+	if !config.Snapshots.Enabled && !config.Snapshots.S3 {
+		return fmt.Errorf("etcd snapshots are disabled: set snapshots.enabled in the config file and run \"up\"")
+	}
+
+	return nil
+}
+
+// runServerCommand runs a command on the server, streaming its output
+func runServerCommand(connections *ssh.Manager, command string) error {
+	sshClient, err := connections.Server()
+	if err != nil {
+		return err
+	}
+
+	_, err = sshClient.Execute(command)
+
+	return err
+}
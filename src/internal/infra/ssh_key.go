@@ -0,0 +1,80 @@
+package infra
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	pec2 "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshPrivateKeyOutput is the name of the ephemeral SSH key in the stack outputs
+const sshPrivateKeyOutput string = "SSH Private Key"
+
+// CreateEphemeralSSHKeyPair registers the cluster's ephemeral public key as an SSH keypair in AWS
+// and exports the private key as a secret stack output, which is the only place it is stored
+func CreateEphemeralSSHKeyPair(ctx *pulumi.Context, name string, privateKey []byte) (*types.Infrastructure, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keypair, err := pec2.NewKeyPair(ctx, "ephemeral-ssh-keypair", &pec2.KeyPairArgs{
+		KeyName:   pulumi.String(name + "-ephemeral-keypair"),
+		PublicKey: pulumi.String(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Export(sshPrivateKeyOutput, pulumi.ToSecret(pulumi.String(privateKey)))
+
+	return &types.Infrastructure{
+		Keypair: keypair,
+	}, nil
+}
+
+// ephemeralSSHKey returns the ephemeral key of a previous run so the instance is not replaced,
+// or generates a new key
+func ephemeralSSHKey(outputs auto.OutputMap) ([]byte, error) {
+	if privateKey := stackSSHKey(outputs); privateKey != nil {
+		return privateKey, nil
+	}
+
+	return generateSSHKey()
+}
+
+// stackSSHKey returns the ephemeral private key from the stack outputs, or nil if there is none
+func stackSSHKey(outputs auto.OutputMap) []byte {
+	output, ok := outputs[sshPrivateKeyOutput]
+	if !ok {
+		return nil
+	}
+
+	privateKey, ok := output.Value.(string)
+	if !ok || privateKey == "" {
+		return nil
+	}
+
+	return []byte(privateKey)
+}
+
+// generateSSHKey generates an ed25519 private key in PEM format
+func generateSSHKey() ([]byte, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
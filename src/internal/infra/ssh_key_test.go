@@ -0,0 +1,37 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateSSHKey(t *testing.T) {
+	privateKey, err := generateSSHKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ssh.KeyAlgoED25519
+	got := signer.PublicKey().Type()
+
+	if expected != got {
+		t.Errorf("expected: %s | got: %s", expected, got)
+	}
+
+	outputs := auto.OutputMap{sshPrivateKeyOutput: {Value: string(privateKey), Secret: true}}
+
+	if string(stackSSHKey(outputs)) != string(privateKey) {
+		t.Errorf("expected: the private key to be read from the stack outputs | got: %q", stackSSHKey(outputs))
+	}
+
+	if stackSSHKey(auto.OutputMap{}) != nil {
+		t.Errorf("expected: no private key without the output | got: %q", stackSSHKey(auto.OutputMap{}))
+	}
+}
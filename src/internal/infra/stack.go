@@ -0,0 +1,138 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// Names of the stack outputs that describe the cluster
+const (
+	clusterNameOutput string = "Cluster Name"
+	instanceIdOutput  string = "Instance ID"
+	publicIpOutput    string = "Public IP Address"
+	hostKeysOutput    string = "SSH Host Keys"
+)
+
+// stackInputs carries the values computed from the stack's previous outputs into the Pulumi program.
+// They are passed in memory rather than as stack configuration, so each secret is only stored
+// once in the stack, as an output
+type stackInputs struct {
+	clusterName   string
+	sshPrivateKey []byte
+	k3sToken      string
+
+	// hostKeys are the host keys pinned by a previous run, by instance ID
+	hostKeys map[string]string
+}
+
+// LoadCluster returns the config file with the state of the provisioned cluster loaded from the
+// Pulumi stack, so every machine with access to the stack works with the same cluster
+func LoadCluster(config types.ConfigFile) (types.ConfigFile, error) {
+	ctx := context.Background()
+
+	stack, err := auto.SelectStackInlineSource(ctx, stackName, projectName, deployInfra(config, &stackInputs{}))
+	if err != nil {
+		return config, err
+	}
+
+	outputs, err := stack.Outputs(ctx)
+	if err != nil {
+		return config, err
+	}
+
+	return applyStackOutputs(config, outputs)
+}
+
+// applyStackOutputs fills in the cluster state from the stack outputs
+func applyStackOutputs(config types.ConfigFile, outputs auto.OutputMap) (types.ConfigFile, error) {
+	name, err := clusterName(config, outputs)
+	if err != nil {
+		return config, err
+	}
+
+	nodes := stackNodes(outputs)
+	if len(nodes) == 0 {
+		return config, fmt.Errorf("stack %s has no cluster, run \"up\" to create one", stackName)
+	}
+
+	config.Cluster = types.Cluster{Name: name, Nodes: nodes}
+	config.K3s.Token = stringOutput(outputs, k3sTokenOutput)
+
+	if config.SSH.Ephemeral {
+		config.SSH.PrivateKey = stackSSHKey(outputs)
+		if config.SSH.PrivateKey == nil {
+			return config, fmt.Errorf("stack %s has no ephemeral ssh key, was the cluster created with ssh.ephemeral set?", stackName)
+		}
+	}
+
+	return config, nil
+}
+
+// clusterName returns the name stored in the stack, or the configured name for a new cluster.
+// Stacks created before the name was stored use the default name they were created with
+func clusterName(config types.ConfigFile, outputs auto.OutputMap) (string, error) {
+	name := stringOutput(outputs, clusterNameOutput)
+
+	switch {
+	case name == "" && config.Name != "":
+		return config.Name, nil
+	case name == "":
+		return utils.GetInstanceName(), nil
+	case config.Name != "" && config.Name != name:
+		return "", fmt.Errorf("the cluster in stack %s is named %s, which does not match name %s in the config file", stackName, name, config.Name)
+	default:
+		return name, nil
+	}
+}
+
+// stackHostKeys returns the pinned host keys from the stack outputs, by instance ID
+func stackHostKeys(outputs auto.OutputMap) map[string]string {
+	hostKeys := map[string]string{}
+
+	output, ok := outputs[hostKeysOutput]
+	if !ok {
+		return hostKeys
+	}
+
+	values, _ := output.Value.(map[string]interface{})
+	for instanceId, value := range values {
+		if keys, ok := value.(string); ok {
+			hostKeys[instanceId] = keys
+		}
+	}
+
+	return hostKeys
+}
+
+// pinHostKeys returns the host keys of an instance. They are only read from the console output
+// the first time, so every later connection is verified against the keys pinned on first use
+func pinHostKeys(region string, instanceId string, pinned map[string]string, progress io.Writer) (map[string]string, error) {
+	keys, ok := pinned[instanceId]
+	if !ok {
+		var err error
+		if keys, err = ssh.FetchHostKeys(region, instanceId, progress); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keys of replaced instances are dropped
+	return map[string]string{instanceId: keys}, nil
+}
+
+// stringOutput returns a string stack output, or an empty string if there is none
+func stringOutput(outputs auto.OutputMap, name string) string {
+	output, ok := outputs[name]
+	if !ok {
+		return ""
+	}
+
+	value, _ := output.Value.(string)
+
+	return value
+}
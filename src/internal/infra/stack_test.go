@@ -0,0 +1,66 @@
+package infra
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// TestApplyStackOutputs tests that the cluster is loaded from the stack outputs rather than the local machine
+func TestApplyStackOutputs(t *testing.T) {
+	hostKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n"
+
+	outputs := auto.OutputMap{
+		clusterNameOutput: {Value: "team-dev"},
+		instanceIdOutput:  {Value: "i-0123456789abcdef0"},
+		publicIpOutput:    {Value: "203.0.113.10"},
+		hostKeysOutput:    {Value: map[string]interface{}{"i-0123456789abcdef0": hostKey}},
+	}
+
+	config, err := applyStackOutputs(types.ConfigFile{}, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Cluster.Name != "team-dev" {
+		t.Errorf("expected: %s | got: %s", "team-dev", config.Cluster.Name)
+	}
+
+	expected := []types.Node{
+		{Name: "server-0", Role: roleServer, InstanceID: "i-0123456789abcdef0", IP: "203.0.113.10", HostKeys: []byte(hostKey)},
+	}
+
+	if !reflect.DeepEqual(expected, config.Cluster.Nodes) {
+		t.Errorf("expected: %v | got: %v", expected, config.Cluster.Nodes)
+	}
+
+	if _, err := applyStackOutputs(types.ConfigFile{Name: "other"}, outputs); err == nil {
+		t.Error("expected an error for a config file name that does not match the stack")
+	}
+
+	if _, err := applyStackOutputs(types.ConfigFile{}, auto.OutputMap{}); err == nil {
+		t.Error("expected an error for a stack without a cluster")
+	}
+
+	if _, err := applyStackOutputs(types.ConfigFile{SSH: types.SSH{Ephemeral: true}}, outputs); err == nil {
+		t.Error("expected an error for an ephemeral key missing from the stack")
+	}
+}
+
+// TestPinHostKeys tests that pinned host keys are reused, and the keys of replaced instances dropped
+func TestPinHostKeys(t *testing.T) {
+	pinned := map[string]string{"i-0123456789abcdef0": "keys", "i-0fedcba9876543210": "old keys"}
+
+	got, err := pinHostKeys("us-east-1", "i-0123456789abcdef0", pinned, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"i-0123456789abcdef0": "keys"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected: %v | got: %v", expected, got)
+	}
+}
@@ -0,0 +1,122 @@
+package infra
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+const (
+	// k3sTokenOutput is the name of the cluster's k3s token in the stack outputs
+	k3sTokenOutput string = "k3s Token"
+
+	// serverTokenPath is where k3s stores the token of a server that was started without one
+	serverTokenPath string = "/var/lib/rancher/k3s/server/token"
+
+	// snapshotTokenObject is the name of the k3s token next to the snapshots in the snapshot bucket
+	snapshotTokenObject string = "token"
+)
+
+// clusterToken returns the k3s token to store in the stack. The token of a previous run is reused.
+// A new cluster with S3 snapshots reuses the token stored next to the snapshots, since the bucket
+// outlives the stack and a cluster can only restore snapshots taken with its own token.
+// Clusters created before tokens were stored keep the token k3s generated for them,
+// which InstallK3s reads from the server, so no token is returned for them
+func clusterToken(config types.ConfigFile, name string, outputs auto.OutputMap) (string, error) {
+	if token := stringOutput(outputs, k3sTokenOutput); token != "" {
+		return token, nil
+	}
+
+	if len(stackNodes(outputs)) > 0 {
+		return "", nil
+	}
+
+	if config.Snapshots.S3 {
+		snapshotBucket, err := snapshotBucketName(config.Region)
+		if err != nil {
+			return "", err
+		}
+
+		data, err := utils.GetObject(config.Region, snapshotBucket, snapshotTokenKey(name))
+		if err != nil {
+			return "", fmt.Errorf("failed reading the k3s token from the snapshot bucket: %w", err)
+		}
+
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	}
+
+	return generateToken()
+}
+
+// serverToken returns the token of a k3s server that was started without one,
+// or an empty string if k3s is not installed
+func serverToken(sshClient *ssh.SSHClient) (string, error) {
+	output, err := sshClient.ExecuteOutput("sudo cat " + ssh.ShellQuote(serverTokenPath) + " 2>/dev/null || true")
+	if err != nil {
+		return "", err
+	}
+
+	return parseServerToken(string(output.StdOut)), nil
+}
+
+// parseServerToken returns the secret part of a k3s server token, which looks like
+// K10<CA hash>::server:<secret>. The secret is what k3s encrypts the datastore's bootstrap data with
+func parseServerToken(token string) string {
+	token = strings.TrimSpace(token)
+
+	if _, secret, ok := strings.Cut(token, "::server:"); ok {
+		return secret
+	}
+
+	return token
+}
+
+// storeSnapshotToken stores the cluster's token next to its snapshots, so a cluster
+// rebuilt from them after "down" is given the same token
+func storeSnapshotToken(config types.ConfigFile, snapshotBucket string) error {
+	key := snapshotTokenKey(config.Cluster.Name)
+
+	data, err := utils.GetObject(config.Region, snapshotBucket, key)
+	if err != nil {
+		return fmt.Errorf("failed reading the k3s token from the snapshot bucket: %w", err)
+	}
+
+	if strings.TrimSpace(string(data)) == config.K3s.Token {
+		return nil
+	}
+
+	if err := utils.PutObject(config.Region, snapshotBucket, key, []byte(config.K3s.Token)); err != nil {
+		return fmt.Errorf("failed storing the k3s token in the snapshot bucket: %w", err)
+	}
+
+	return nil
+}
+
+// generateToken returns a random k3s token
+func generateToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// snapshotTokenKey returns the key of the cluster's token in the snapshot bucket
+func snapshotTokenKey(name string) string {
+	return path.Join(name, snapshotTokenObject)
+}
+
+// snapshotFolder returns the folder of the cluster's snapshots in the snapshot bucket
+func snapshotFolder(config types.ConfigFile) string {
+	return config.Cluster.Name
+}
@@ -0,0 +1,208 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Tunnel forwards local ports and runs a SOCKS5 proxy over the SSH connection to the server
+// until it is interrupted
+func Tunnel(config types.ConfigFile, opts types.TunnelOptions) error {
+	if len(opts.Forwards) == 0 && opts.SOCKSAddr == "" {
+		return fmt.Errorf("nothing to forward, set at least one port forward or a SOCKS proxy address")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	sshClient, err := connections.Server()
+	if err != nil {
+		return err
+	}
+
+	if opts.KubeconfigOut != "" {
+		if err := writeTunnelKubeconfig(config, connections, opts); err != nil {
+			return err
+		}
+	}
+
+	// A failing forward stops the whole tunnel
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(opts.Forwards)+1)
+	running := 0
+
+	for _, forward := range opts.Forwards {
+		forward := forward
+
+		fmt.Printf("Forwarding %s to %s on the server\n", forward.LocalAddr, forward.RemoteAddr)
+
+		running++
+		go func() {
+			errs <- sshClient.Forward(ctx, forward.LocalAddr, forward.RemoteAddr)
+		}()
+	}
+
+	if opts.SOCKSAddr != "" {
+		fmt.Printf("SOCKS5 proxy listening on %s\n", opts.SOCKSAddr)
+
+		running++
+		go func() {
+			errs <- sshClient.ServeSOCKS(ctx, opts.SOCKSAddr)
+		}()
+	}
+
+	fmt.Println("Press Ctrl+C to close the tunnel")
+
+	var firstErr error
+	for i := 0; i < running; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	return firstErr
+}
+
+// ParseForward parses a forward in the format of ssh -L: [bind_address:]port:host:hostport.
+// Like ssh, IPv6 addresses are written in brackets, e.g. [::1]:6443:[fd00::1]:6443
+func ParseForward(spec string) (types.Forward, error) {
+	parts, ok := splitForwardSpec(spec)
+	if !ok {
+		return types.Forward{}, fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+
+	switch len(parts) {
+	case 3:
+		// Only listen on the loopback interface unless a bind address is given
+		parts = append([]string{"127.0.0.1"}, parts...)
+	case 4:
+	default:
+		return types.Forward{}, fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+
+	for _, part := range parts[1:] {
+		if part == "" {
+			return types.Forward{}, fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+		}
+	}
+
+	return types.Forward{
+		LocalAddr:  net.JoinHostPort(parts[0], parts[1]),
+		RemoteAddr: net.JoinHostPort(parts[2], parts[3]),
+	}, nil
+}
+
+// splitForwardSpec splits a forward on colons. An address in brackets is a single field,
+// returned without its brackets
+func splitForwardSpec(spec string) ([]string, bool) {
+	var fields []string
+
+	for {
+		var field string
+
+		if strings.HasPrefix(spec, "[") {
+			end := strings.Index(spec, "]")
+			if end < 0 {
+				return nil, false
+			}
+
+			field, spec = spec[1:end], spec[end+1:]
+			if spec == "" {
+				return append(fields, field), true
+			}
+
+			if spec[0] != ':' {
+				return nil, false
+			}
+
+			spec = spec[1:]
+		} else {
+			var found bool
+			if field, spec, found = strings.Cut(spec, ":"); !found {
+				return append(fields, field), true
+			}
+		}
+
+		fields = append(fields, field)
+	}
+}
+
+// writeTunnelKubeconfig writes a kubeconfig whose server is the local end of the forward to the API server
+func writeTunnelKubeconfig(config types.ConfigFile, connections *ssh.Manager, opts types.TunnelOptions) error {
+	var localAddr string
+	for _, forward := range opts.Forwards {
+		if _, port, _ := net.SplitHostPort(forward.RemoteAddr); port == apiServerPort {
+			localAddr = forward.LocalAddr
+			break
+		}
+	}
+
+	if localAddr == "" {
+		return fmt.Errorf("a kubeconfig needs a forward to the API server, e.g. -L %s:127.0.0.1:%s", apiServerPort, apiServerPort)
+	}
+
+	kubeconfig, err := fetchKubeconfig(config, connections)
+	if err != nil {
+		return err
+	}
+
+	tunnelKubeconfig, err := pointKubeconfigAt(kubeconfig, localAddr)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(opts.KubeconfigOut, tunnelKubeconfig); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote kubeconfig for the tunnel to %s\n", opts.KubeconfigOut)
+
+	return nil
+}
+
+// pointKubeconfigAt sets the server of every cluster in the kubeconfig to the given address.
+// The k3s serving certificate is valid for 127.0.0.1, so a loopback address needs no extra tls-san
+func pointKubeconfigAt(kubeconfig []byte, addr string) ([]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// A forward listening on every interface is reached through the loopback interface
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	for _, cluster := range config.Clusters {
+		serverURL, err := url.Parse(cluster.Server)
+		if err != nil {
+			return nil, err
+		}
+
+		serverURL.Host = net.JoinHostPort(host, port)
+		cluster.Server = serverURL.String()
+	}
+
+	return clientcmd.Write(*config)
+}
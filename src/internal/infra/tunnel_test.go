@@ -0,0 +1,48 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected types.Forward
+	}{
+		{
+			spec:     "6443:127.0.0.1:6443",
+			expected: types.Forward{LocalAddr: "127.0.0.1:6443", RemoteAddr: "127.0.0.1:6443"},
+		},
+		{
+			spec:     "0.0.0.0:8080:10.43.0.10:80",
+			expected: types.Forward{LocalAddr: "0.0.0.0:8080", RemoteAddr: "10.43.0.10:80"},
+		},
+		{
+			spec:     "[::1]:6443:[fd00::1]:6443",
+			expected: types.Forward{LocalAddr: "[::1]:6443", RemoteAddr: "[fd00::1]:6443"},
+		},
+		{
+			spec:     "8080:[fd00:10:43::10]:80",
+			expected: types.Forward{LocalAddr: "127.0.0.1:8080", RemoteAddr: "[fd00:10:43::10]:80"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseForward(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.spec, err)
+		}
+
+		if got != tt.expected {
+			t.Errorf("%s: expected: %+v | got: %+v", tt.spec, tt.expected, got)
+		}
+	}
+
+	for _, spec := range []string{"6443", "6443:127.0.0.1", ":127.0.0.1:6443", "8080:fd00::1:80", "8080:[fd00::1:80", "8080:[fd00::1]80", "[::1]:6443:127.0.0.1:"} {
+		if _, err := ParseForward(spec); err == nil {
+			t.Errorf("%s: expected: error | got: nil", spec)
+		}
+	}
+}
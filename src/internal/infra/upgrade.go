@@ -0,0 +1,152 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	ssh "github.com/lucasrod16/ec2-k3s/src/internal/ssh-client"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Upgrade upgrades k3s in place to the given version, servers first and then agents.
+// Nodes are upgraded one at a time and the upgrade stops at the first node that fails
+func Upgrade(config types.ConfigFile, version string) error {
+	nodes, err := GetNodes(config)
+	if err != nil {
+		return err
+	}
+
+	connections := ssh.NewManager(config)
+	defer connections.Close()
+
+	kubeconfig, err := fetchKubeconfig(config, connections)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := newKubeClient(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	k3s := config.K3s
+	k3s.Version = version
+
+	timeout := config.ReadinessTimeout
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	for _, node := range nodes {
+		if err := upgradeNode(clientset, connections, node, k3s, timeout, config.ProgressWriter()); err != nil {
+			return fmt.Errorf("upgrade aborted at node %s: %w", node.Name, err)
+		}
+	}
+
+	fmt.Fprintf(config.ProgressWriter(), "All nodes upgraded to %s\n", version)
+
+	return nil
+}
+
+// upgradeNeeded reports whether a node at the installed k3s version must be upgraded to the desired version.
+// k3s cannot be downgraded, so a desired version older than the installed one is an error
+func upgradeNeeded(installedVersion, desiredVersion string) (bool, error) {
+	if installedVersion == "" {
+		return false, fmt.Errorf("k3s is not installed, run \"up\" to install it")
+	}
+
+	order, err := compareK3sVersions(installedVersion, desiredVersion)
+	if err != nil {
+		return false, err
+	}
+
+	if order > 0 {
+		return false, fmt.Errorf("k3s %s is installed, which is newer than %s. k3s cannot be downgraded", installedVersion, desiredVersion)
+	}
+
+	return order < 0, nil
+}
+
+// upgradeNode cordons and drains a node, re-runs the k3s installer and waits for the
+// node to come back at the new version before uncordoning it. A node that does not
+// come back is left cordoned so it can be investigated
+func upgradeNode(clientset *kubernetes.Clientset, connections *ssh.Manager, node types.Node, k3s types.K3s, timeout time.Duration, progress io.Writer) error {
+	sshClient, err := connections.Connect(node)
+	if err != nil {
+		return err
+	}
+
+	// The Kubernetes node name is the hostname of the ec2 instance
+	output, err := sshClient.ExecuteOutput("hostname")
+	if err != nil {
+		return err
+	}
+
+	nodeName := strings.TrimSpace(string(output.StdOut))
+
+	if _, err := clientset.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{}); err != nil {
+		return err
+	}
+
+	installedVersion, err := installedK3sVersion(sshClient)
+	if err != nil {
+		return err
+	}
+
+	// The version is checked before the node is cordoned, so a refused upgrade leaves the node untouched
+	upgrade, err := upgradeNeeded(installedVersion, k3s.Version)
+	if err != nil {
+		return err
+	}
+
+	if !upgrade {
+		fmt.Fprintf(progress, "Node %s is already at %s, skipping\n", node.Name, k3s.Version)
+		return nil
+	}
+
+	fmt.Fprintf(progress, "Upgrading node %s (%s) from %s to %s\n", node.Name, nodeName, installedVersion, k3s.Version)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+
+	fmt.Fprintf(progress, "Cordoning and draining node %s\n", node.Name)
+
+	if err := cordonNode(drainCtx, clientset, nodeName, true); err != nil {
+		return err
+	}
+
+	if err := drainNode(drainCtx, clientset, nodeName); err != nil {
+		return err
+	}
+
+	installK3sCommand, err := k3sInstallCommand(sshClient, k3s, progress)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sshClient.Execute(installK3sCommand); err != nil {
+		return err
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), timeout)
+	defer cancelWait()
+
+	fmt.Fprintf(progress, "Waiting for node %s to be Ready at %s\n", node.Name, k3s.Version)
+
+	if err := waitNodeVersion(waitCtx, clientset, nodeName, k3s.Version); err != nil {
+		return err
+	}
+
+	if err := cordonNode(waitCtx, clientset, nodeName, false); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "Node %s upgraded to %s\n", node.Name, k3s.Version)
+
+	return nil
+}
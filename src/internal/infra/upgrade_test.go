@@ -0,0 +1,43 @@
+package infra
+
+import (
+	"testing"
+)
+
+// TestUpgradeNeeded tests that nodes are only upgraded to newer k3s releases
+func TestUpgradeNeeded(t *testing.T) {
+	tests := []struct {
+		installedVersion string
+		desiredVersion   string
+		expected         bool
+	}{
+		{installedVersion: "v1.27.4+k3s1", desiredVersion: "v1.27.4+k3s2", expected: true},
+		{installedVersion: "v1.26.7+k3s1", desiredVersion: "v1.27.4+k3s1", expected: true},
+		{installedVersion: "v1.27.4+k3s1", desiredVersion: "v1.27.4+k3s1", expected: false},
+	}
+
+	for _, test := range tests {
+		upgrade, err := upgradeNeeded(test.installedVersion, test.desiredVersion)
+		if err != nil {
+			t.Errorf("expected: no error | got: %s", err)
+			continue
+		}
+
+		if upgrade != test.expected {
+			t.Errorf("%s to %s: expected: %t | got: %t", test.installedVersion, test.desiredVersion, test.expected, upgrade)
+		}
+	}
+}
+
+// TestUpgradeNeededDowngrade tests that a node is never downgraded
+func TestUpgradeNeededDowngrade(t *testing.T) {
+	for _, desiredVersion := range []string{"v1.27.4+k3s1", "v1.26.7+k3s2", "1.28"} {
+		if _, err := upgradeNeeded("v1.27.4+k3s2", desiredVersion); err == nil {
+			t.Errorf("desired %q: expected: error | got: nil", desiredVersion)
+		}
+	}
+
+	if _, err := upgradeNeeded("", "v1.27.4+k3s2"); err == nil {
+		t.Error("expected an error when k3s is not installed")
+	}
+}
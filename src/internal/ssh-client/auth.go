@@ -0,0 +1,279 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// defaultPrivateKeyFiles are the private keys looked for in the home directory, in order of preference.
+// ECDSA keys are not looked for, since EC2 cannot import them as the key installed on the instance
+var defaultPrivateKeyFiles = []string{".ssh/id_ed25519", ".ssh/id_rsa"}
+
+// decryptedSigners caches keys decrypted with a passphrase,
+// so the passphrase is asked for at most once per run
+var (
+	decryptedSigners   = map[string]ssh.Signer{}
+	decryptedSignersMu sync.Mutex
+)
+
+// PublicKey returns the public key to install on the ec2 instance in authorized_keys format.
+// If no key file exists, the first RSA or ed25519 key held by the SSH agent is used
+func PublicKey(sshConfig types.SSH) ([]byte, error) {
+	publicKeyPath, err := publicKeyPath(sshConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKeyPath != "" {
+		keyData, err := os.ReadFile(publicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading public ssh key: %w", err)
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing public ssh key %s: %w", publicKeyPath, err)
+		}
+
+		if !importableKey(key) {
+			return nil, fmt.Errorf("ec2 key pairs must be RSA or ed25519 keys, but %s is a %s key. Other keys can only authenticate through the ssh agent", publicKeyPath, key.Type())
+		}
+
+		return keyData, nil
+	}
+
+	agentClient, closeAgent, err := connectAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	if agentClient == nil {
+		return nil, fmt.Errorf("no ssh key found: set ssh.privateKeyPath, create ~/.ssh/id_ed25519 or add a key to the ssh agent")
+	}
+
+	defer closeAgent()
+
+	keys, err := agentClient.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if importableKey(key) {
+			return ssh.MarshalAuthorizedKey(key), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no RSA or ed25519 ssh key found: set ssh.privateKeyPath, create ~/.ssh/id_ed25519 or add a key to the ssh agent")
+}
+
+// importableKey returns whether EC2 can import a key as a key pair, which only RSA and ed25519 keys can be
+func importableKey(key ssh.PublicKey) bool {
+	switch key.Type() {
+	case ssh.KeyAlgoRSA, ssh.KeyAlgoED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+// authMethod authenticates with the cluster's ephemeral key if it has one.
+// Otherwise it uses the keys held by the SSH agent, followed by the private key file.
+// The private key file is only read if the agent does not hold its key, and its passphrase
+// is asked for if it is encrypted. The returned function closes the agent connection
+func authMethod(sshConfig types.SSH) (ssh.AuthMethod, func(), error) {
+	if sshConfig.Ephemeral {
+		if len(sshConfig.PrivateKey) == 0 {
+			return nil, nil, fmt.Errorf("the cluster's ephemeral ssh key has not been loaded")
+		}
+
+		signer, err := ssh.ParsePrivateKey(sshConfig.PrivateKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed parsing the cluster's ephemeral ssh key: %w", err)
+		}
+
+		return ssh.PublicKeys(signer), func() {}, nil
+	}
+
+	agentClient, closeAgent, err := connectAgent()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKeyPath, err := privateKeyPath(sshConfig)
+	if err != nil {
+		closeAgent()
+		return nil, nil, err
+	}
+
+	publicKeyPath, err := publicKeyPath(sshConfig)
+	if err != nil {
+		closeAgent()
+		return nil, nil, err
+	}
+
+	if agentClient == nil && privateKeyPath == "" {
+		return nil, nil, fmt.Errorf("no ssh key found: set ssh.privateKeyPath, create ~/.ssh/id_ed25519 or add a key to the ssh agent")
+	}
+
+	signers := func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+
+		if agentClient != nil {
+			agentSigners, err := agentClient.Signers()
+			if err != nil {
+				return nil, err
+			}
+
+			signers = append(signers, agentSigners...)
+
+			if agentHoldsKey(agentSigners, publicKeyPath) {
+				return signers, nil
+			}
+		}
+
+		if privateKeyPath != "" {
+			signer, err := loadPrivateKey(privateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+
+			signers = append(signers, signer)
+		}
+
+		return signers, nil
+	}
+
+	return ssh.PublicKeysCallback(signers), closeAgent, nil
+}
+
+// connectAgent connects to the SSH agent at $SSH_AUTH_SOCK.
+// It returns a nil client if no agent is running
+func connectAgent() (agent.ExtendedAgent, func(), error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, func() {}, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed connecting to the ssh agent at %s: %w", socket, err)
+	}
+
+	return agent.NewClient(conn), func() { conn.Close() }, nil
+}
+
+// agentHoldsKey returns whether one of the agent's keys is the public key at publicKeyPath
+func agentHoldsKey(agentSigners []ssh.Signer, publicKeyPath string) bool {
+	if publicKeyPath == "" {
+		return false
+	}
+
+	keyData, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return false
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(keyData)
+	if err != nil {
+		return false
+	}
+
+	for _, signer := range agentSigners {
+		if bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadPrivateKey parses an RSA, ECDSA or ed25519 private key,
+// asking for its passphrase on the terminal if it is encrypted
+func loadPrivateKey(keyPath string) (ssh.Signer, error) {
+	decryptedSignersMu.Lock()
+	defer decryptedSignersMu.Unlock()
+
+	if signer, ok := decryptedSigners[keyPath]; ok {
+		return signer, nil
+	}
+
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading private ssh key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyData)
+
+	var passphraseErr *ssh.PassphraseMissingError
+	if !errors.As(err, &passphraseErr) {
+		return signer, err
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("private ssh key %s is encrypted: add it to the ssh agent or run interactively to enter its passphrase", keyPath)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", keyPath)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting private ssh key %s: %w", keyPath, err)
+	}
+
+	decryptedSigners[keyPath] = signer
+
+	return signer, nil
+}
+
+// privateKeyPath returns the configured private key, or the first default key that exists.
+// It returns an empty path if no default key exists
+func privateKeyPath(sshConfig types.SSH) (string, error) {
+	if sshConfig.PrivateKeyPath != "" {
+		return utils.ExpandHome(sshConfig.PrivateKeyPath)
+	}
+
+	userHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	for _, keyFile := range defaultPrivateKeyFiles {
+		keyPath := filepath.Join(userHomeDir, keyFile)
+		if _, err := os.Stat(keyPath); err == nil {
+			return keyPath, nil
+		}
+	}
+
+	return "", nil
+}
+
+// publicKeyPath returns the configured public key, or the private key path with a .pub suffix
+func publicKeyPath(sshConfig types.SSH) (string, error) {
+	if sshConfig.PublicKeyPath != "" {
+		return utils.ExpandHome(sshConfig.PublicKeyPath)
+	}
+
+	privateKeyPath, err := privateKeyPath(sshConfig)
+	if err != nil || privateKeyPath == "" {
+		return "", err
+	}
+
+	return privateKeyPath + ".pub", nil
+}
@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeyPaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// ed25519 is preferred over rsa when both exist
+	for _, keyFile := range []string{".ssh/id_rsa", ".ssh/id_ed25519"} {
+		if err := os.WriteFile(filepath.Join(home, keyFile), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		sshConfig       types.SSH
+		expectedPrivate string
+		expectedPublic  string
+	}{
+		{
+			name:            "defaults",
+			sshConfig:       types.SSH{},
+			expectedPrivate: filepath.Join(home, ".ssh/id_ed25519"),
+			expectedPublic:  filepath.Join(home, ".ssh/id_ed25519.pub"),
+		},
+		{
+			name:            "private key path",
+			sshConfig:       types.SSH{PrivateKeyPath: "~/keys/ec2"},
+			expectedPrivate: filepath.Join(home, "keys/ec2"),
+			expectedPublic:  filepath.Join(home, "keys/ec2.pub"),
+		},
+		{
+			name:            "both paths",
+			sshConfig:       types.SSH{PrivateKeyPath: "/keys/ec2", PublicKeyPath: "/keys/other.pub"},
+			expectedPrivate: "/keys/ec2",
+			expectedPublic:  "/keys/other.pub",
+		},
+	}
+
+	for _, tt := range tests {
+		gotPrivate, err := privateKeyPath(tt.sshConfig)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if gotPrivate != tt.expectedPrivate {
+			t.Errorf("%s: expected: %s | got: %s", tt.name, tt.expectedPrivate, gotPrivate)
+		}
+
+		gotPublic, err := publicKeyPath(tt.sshConfig)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if gotPublic != tt.expectedPublic {
+			t.Errorf("%s: expected: %s | got: %s", tt.name, tt.expectedPublic, gotPublic)
+		}
+	}
+}
+
+func TestPublicKeyType(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaPublicKey, err := ssh.NewPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed25519PublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fallbackPublicKey, err := ssh.NewPublicKey(ed25519PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		".ssh/id_ecdsa":     nil,
+		".ssh/id_ecdsa.pub": ssh.MarshalAuthorizedKey(ecdsaPublicKey),
+		".ssh/id_rsa":       nil,
+		".ssh/id_rsa.pub":   ssh.MarshalAuthorizedKey(fallbackPublicKey),
+	}
+
+	for keyFile, data := range files {
+		if err := os.WriteFile(filepath.Join(home, keyFile), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ECDSA key is skipped in favor of the next default key
+	got, err := PublicKey(types.SSH{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != string(files[".ssh/id_rsa.pub"]) {
+		t.Errorf("expected: %s | got: %s", files[".ssh/id_rsa.pub"], got)
+	}
+
+	if _, err := PublicKey(types.SSH{PrivateKeyPath: "~/.ssh/id_ecdsa"}); err == nil {
+		t.Errorf("expected: error for an ECDSA key | got: nil")
+	}
+}
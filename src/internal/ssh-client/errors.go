@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// outputTailSize is how much of the end of a failed command's output is kept in its error
+const outputTailSize = 4096
+
+// RemoteCommandError is returned when a command run on the remote machine fails
+type RemoteCommandError struct {
+	Command string
+
+	// ExitStatus is -1 if the command did not report an exit status, e.g. because it was killed
+	ExitStatus int
+
+	// Signal is the signal that terminated the command, if any
+	Signal string
+
+	// StdOut and StdErr hold the end of the command's output
+	StdOut []byte
+	StdErr []byte
+
+	Duration time.Duration
+
+	Err error
+}
+
+func newRemoteCommandError(command string, err error, stdout, stderr []byte, duration time.Duration) *RemoteCommandError {
+	commandErr := &RemoteCommandError{
+		Command:    command,
+		ExitStatus: -1,
+		StdOut:     tail(stdout),
+		StdErr:     tail(stderr),
+		Duration:   duration,
+		Err:        err,
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		commandErr.ExitStatus = exitErr.ExitStatus()
+		commandErr.Signal = exitErr.Signal()
+	}
+
+	return commandErr
+}
+
+func (e *RemoteCommandError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "remote command %q ", e.Command)
+
+	switch {
+	case e.Signal != "":
+		fmt.Fprintf(&b, "was killed by signal %s", e.Signal)
+	case e.ExitStatus >= 0:
+		fmt.Fprintf(&b, "exited with status %d", e.ExitStatus)
+	default:
+		fmt.Fprintf(&b, "failed: %s", e.Err)
+	}
+
+	fmt.Fprintf(&b, " after %s", e.Duration.Round(time.Millisecond))
+
+	if stderr := strings.TrimSpace(string(e.StdErr)); stderr != "" {
+		fmt.Fprintf(&b, "\nstderr:\n%s", stderr)
+	}
+
+	return b.String()
+}
+
+func (e *RemoteCommandError) Unwrap() error {
+	return e.Err
+}
+
+// tail returns a copy of the last outputTailSize bytes of the output
+func tail(output []byte) []byte {
+	if len(output) > outputTailSize {
+		output = output[len(output)-outputTailSize:]
+	}
+
+	return append([]byte(nil), output...)
+}
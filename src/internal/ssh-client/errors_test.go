@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteCommandError(t *testing.T) {
+	stderr := append(bytes.Repeat([]byte("x"), outputTailSize), []byte("\ncurl: (6) Could not resolve host\n")...)
+
+	err := newRemoteCommandError("curl -sfL https://get.k3s.io | sh -", io.EOF, nil, stderr, 1500*time.Millisecond)
+
+	if len(err.StdErr) != outputTailSize {
+		t.Errorf("expected: %d | got: %d", outputTailSize, len(err.StdErr))
+	}
+
+	if err.ExitStatus != -1 {
+		t.Errorf("expected: %d | got: %d", -1, err.ExitStatus)
+	}
+
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected: error to wrap %s | got: %s", io.EOF, err.Err)
+	}
+
+	for _, expected := range []string{"failed: EOF after 1.5s", "Could not resolve host"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected: %s | got: %s", expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// SOCKS5 protocol values, see RFC 1928
+const (
+	socksVersion         byte = 0x05
+	socksNoAuth          byte = 0x00
+	socksNoAcceptable    byte = 0xff
+	socksConnect         byte = 0x01
+	socksAddrIPv4        byte = 0x01
+	socksAddrDomain      byte = 0x03
+	socksAddrIPv6        byte = 0x04
+	socksSucceeded       byte = 0x00
+	socksHostUnreachable byte = 0x04
+	socksCmdUnsupported  byte = 0x07
+)
+
+// Forward listens on localAddr and proxies every connection to remoteAddr,
+// which is dialed from the remote machine. It runs until the context is cancelled
+func (s SSHClient) Forward(ctx context.Context, localAddr, remoteAddr string) error {
+	return s.serve(ctx, localAddr, func(conn net.Conn) {
+		remote, err := s.conn.Dial("tcp", remoteAddr)
+		if err != nil {
+			fmt.Printf("Forwarding %s to %s failed: %s\n", conn.RemoteAddr(), remoteAddr, err)
+			return
+		}
+
+		proxy(conn, remote)
+	})
+}
+
+// ServeSOCKS runs a SOCKS5 proxy on localAddr that connects to any address
+// the remote machine can reach. It runs until the context is cancelled
+func (s SSHClient) ServeSOCKS(ctx context.Context, localAddr string) error {
+	return s.serve(ctx, localAddr, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+
+		target, err := socksHandshake(reader, conn)
+		if err != nil {
+			fmt.Printf("SOCKS request from %s failed: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+
+		remote, err := s.conn.Dial("tcp", target)
+		if err != nil {
+			socksReply(conn, socksHostUnreachable)
+			fmt.Printf("SOCKS connection to %s failed: %s\n", target, err)
+			return
+		}
+
+		if err := socksReply(conn, socksSucceeded); err != nil {
+			remote.Close()
+			return
+		}
+
+		// The reader may hold data the client sent right after its request
+		proxy(&bufferedConn{Conn: conn, reader: reader}, remote)
+	})
+}
+
+// serve accepts connections on localAddr and handles each in its own goroutine
+// until the context is cancelled
+func (s SSHClient) serve(ctx context.Context, localAddr string, handle func(conn net.Conn)) error {
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return err
+	}
+
+	defer closeOnCancel(ctx, listener)()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			// Close the connection when the context is cancelled so it does not outlive serve
+			defer closeOnCancel(ctx, conn)()
+
+			handle(conn)
+		}()
+	}
+}
+
+// proxy copies data in both directions until both sides have finished sending.
+// A side that finishes sending is half-closed on the other end, so a client that closes
+// its write side after a request still receives the whole response
+func proxy(local, remote net.Conn) {
+	defer remote.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		copyHalf(remote, local)
+	}()
+
+	go func() {
+		defer wg.Done()
+		copyHalf(local, remote)
+	}()
+
+	wg.Wait()
+}
+
+// copyHalf copies src to dst and closes the write side of dst once src is done sending.
+// If the copy fails, for example because a connection was closed, both connections are closed
+// so the copy in the other direction stops as well
+func copyHalf(dst, src net.Conn) {
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		src.Close()
+		return
+	}
+
+	if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+		conn.CloseWrite()
+		return
+	}
+
+	dst.Close()
+}
+
+// socksHandshake negotiates a SOCKS5 connection without authentication
+// and returns the address the client asked to connect to
+func socksHandshake(reader *bufio.Reader, w io.Writer) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return "", err
+	}
+
+	if !containsByte(methods, socksNoAuth) {
+		w.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", errors.New("the client requires authentication, which is not supported")
+	}
+
+	if _, err := w.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return "", err
+	}
+
+	if request[1] != socksConnect {
+		socksReply(w, socksCmdUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+
+		host = net.IP(ip).String()
+
+	case socksAddrDomain:
+		size, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		domain := make([]byte, size)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+
+		// The domain is resolved by the remote machine
+		host = string(domain)
+
+	default:
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply answers a SOCKS5 request. The bound address is not meaningful for a proxied connection
+func socksReply(w io.Writer, status byte) error {
+	_, err := w.Write([]byte{socksVersion, status, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func containsByte(list []byte, value byte) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bufferedConn reads through a buffered reader that may already hold data from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite half-closes the underlying connection if it supports it
+func (c *bufferedConn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+
+	return c.Conn.Close()
+}
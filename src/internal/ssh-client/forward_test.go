@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSOCKSHandshake(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  []byte
+		expected string
+	}{
+		{
+			name:     "domain",
+			request:  []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, 0x0b, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'o', 'r', 'g', 0x00, 0x50},
+			expected: "example.org:80",
+		},
+		{
+			name:     "ipv4",
+			request:  []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 10, 43, 0, 1, 0x01, 0xbb},
+			expected: "10.43.0.1:443",
+		},
+	}
+
+	for _, tt := range tests {
+		reply := bytes.Buffer{}
+
+		got, err := socksHandshake(bufio.NewReader(bytes.NewReader(tt.request)), &reply)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if got != tt.expected {
+			t.Errorf("%s: expected: %s | got: %s", tt.name, tt.expected, got)
+		}
+
+		if !bytes.Equal(reply.Bytes(), []byte{socksVersion, socksNoAuth}) {
+			t.Errorf("%s: expected: %v | got: %v", tt.name, []byte{socksVersion, socksNoAuth}, reply.Bytes())
+		}
+	}
+
+	// Clients that require a username and password are rejected
+	reply := bytes.Buffer{}
+	if _, err := socksHandshake(bufio.NewReader(bytes.NewReader([]byte{0x05, 0x01, 0x02})), &reply); err == nil {
+		t.Errorf("expected: error for a client requiring authentication | got: nil")
+	}
+}
+
+// TestProxyHalfClose tests that a client that closes its write side after a request still gets the response
+func TestProxyHalfClose(t *testing.T) {
+	clientConn, localConn := tcpPair(t)
+	remoteConn, serverConn := tcpPair(t)
+
+	done := make(chan struct{})
+	go func() {
+		proxy(localConn, remoteConn)
+		close(done)
+	}()
+
+	// The server answers once the whole request has been received
+	go func() {
+		request, _ := io.ReadAll(serverConn)
+		serverConn.Write(append([]byte("response to "), request...))
+		serverConn.Close()
+	}()
+
+	clientConn.Write([]byte("request"))
+	clientConn.(*net.TCPConn).CloseWrite()
+
+	response, err := io.ReadAll(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(response) != "response to request" {
+		t.Errorf("expected: %s | got: %s", "response to request", response)
+	}
+
+	clientConn.Close()
+	<-done
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("failed accepting a loopback connection")
+	}
+
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+
+	return dialed, conn
+}
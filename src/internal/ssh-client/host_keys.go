@@ -0,0 +1,148 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// cloud-init prints the instance's host keys between these markers on the serial console
+const (
+	hostKeysBegin string = "-----BEGIN SSH HOST KEY KEYS-----"
+	hostKeysEnd   string = "-----END SSH HOST KEY KEYS-----"
+)
+
+// hostKeysTimeout is how long to wait for cloud-init to print the host keys after boot.
+// Instances that are not built on Nitro only capture their console output every few minutes
+const hostKeysTimeout = 10 * time.Minute
+
+// FetchHostKeys reads the host keys of an ec2 instance from its console output and returns them
+// in authorized_keys format, to be pinned in the cluster's state. The console output is fetched
+// through the authenticated AWS API, so it cannot be tampered with by anyone on the network path
+// to the instance. Progress is reported to progress
+func FetchHostKeys(region, instanceId string, progress io.Writer) (string, error) {
+	startTime := time.Now()
+
+	fmt.Fprintf(progress, "Fetching the SSH host keys of %s from its console output...\n", instanceId)
+
+	for {
+		output, err := utils.GetConsoleOutput(region, instanceId)
+		if err != nil {
+			return "", err
+		}
+
+		keys, err := parseConsoleHostKeys(output)
+		if err != nil {
+			return "", err
+		}
+
+		if len(keys) > 0 {
+			var data []byte
+			for _, key := range keys {
+				data = append(data, ssh.MarshalAuthorizedKey(key)...)
+			}
+
+			fmt.Fprintf(progress, "Pinned %d SSH host keys of %s\n", len(keys), instanceId)
+
+			return string(data), nil
+		}
+
+		if time.Since(startTime) >= hostKeysTimeout {
+			return "", fmt.Errorf("timed out waiting for %s to print its SSH host keys to the console", instanceId)
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// hostKeyCallback verifies that the node presents one of the host keys pinned in the cluster's state
+func hostKeyCallback(node types.Node) (ssh.HostKeyCallback, error) {
+	if len(node.HostKeys) == 0 {
+		return nil, fmt.Errorf("no SSH host keys are pinned for %s (%s), run \"up\" to pin them", node.Name, node.InstanceID)
+	}
+
+	keys, err := parseHostKeys(node.HostKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyHostKey(node.InstanceID, keys), nil
+}
+
+// verifyHostKey returns a callback that rejects any host key that is not one of the pinned keys
+func verifyHostKey(instanceId string, pinned []ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, pinnedKey := range pinned {
+			if bytes.Equal(pinnedKey.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf(
+			"host key mismatch for %s (%s): it presented %s key %s, which does not match the keys pinned for the instance. "+
+				"The connection may have been intercepted, so it was aborted",
+			hostname, instanceId, key.Type(), ssh.FingerprintSHA256(key),
+		)
+	}
+}
+
+// parseConsoleHostKeys extracts the host keys cloud-init printed in the console output.
+// It returns no keys if they have not been printed yet
+func parseConsoleHostKeys(output string) ([]ssh.PublicKey, error) {
+	begin := strings.LastIndex(output, hostKeysBegin)
+	if begin == -1 {
+		return nil, nil
+	}
+
+	block := output[begin+len(hostKeysBegin):]
+
+	end := strings.Index(block, hostKeysEnd)
+	if end == -1 {
+		// The console output was captured while the keys were being printed
+		return nil, nil
+	}
+
+	var keys []ssh.PublicKey
+
+	scanner := bufio.NewScanner(strings.NewReader(block[:end]))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing host key %q from console output: %w", line, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, scanner.Err()
+}
+
+// parseHostKeys parses host keys in authorized_keys format
+func parseHostKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing pinned host keys: %w", err)
+		}
+
+		keys = append(keys, key)
+		data = rest
+	}
+
+	return keys, nil
+}
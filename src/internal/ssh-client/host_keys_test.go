@@ -0,0 +1,100 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func generateTestHostKey(t *testing.T) ssh.PublicKey {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestParseConsoleHostKeys(t *testing.T) {
+	key := generateTestHostKey(t)
+	keyLine := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+
+	tests := []struct {
+		name     string
+		output   string
+		expected int
+	}{
+		{
+			name:     "not printed yet",
+			output:   "[   10.000000] cloud-init[500]: Cloud-init v. 23.1 running 'init'\n",
+			expected: 0,
+		},
+		{
+			name:     "partially printed",
+			output:   hostKeysBegin + "\n" + keyLine + "\n",
+			expected: 0,
+		},
+		{
+			name:     "printed",
+			output:   "boot messages\n" + hostKeysBegin + "\n" + keyLine + " root@ip-10-0-0-1\n" + hostKeysEnd + "\nmore boot messages\n",
+			expected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		keys, err := parseConsoleHostKeys(tt.output)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if len(keys) != tt.expected {
+			t.Errorf("%s: expected: %d | got: %d", tt.name, tt.expected, len(keys))
+		}
+	}
+}
+
+func TestVerifyHostKey(t *testing.T) {
+	pinned := generateTestHostKey(t)
+	other := generateTestHostKey(t)
+
+	callback := verifyHostKey("i-0123456789abcdef0", []ssh.PublicKey{pinned})
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	if err := callback("192.0.2.1:22", addr, pinned); err != nil {
+		t.Errorf("expected: pinned key to be accepted | got: %s", err)
+	}
+
+	if err := callback("192.0.2.1:22", addr, other); err == nil {
+		t.Errorf("expected: unpinned key to be rejected | got: nil")
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	key := generateTestHostKey(t)
+	node := types.Node{Name: "server-0", InstanceID: "i-0123456789abcdef0"}
+
+	if _, err := hostKeyCallback(node); err == nil {
+		t.Errorf("expected: error for a node without pinned host keys | got: nil")
+	}
+
+	node.HostKeys = ssh.MarshalAuthorizedKey(key)
+
+	callback, err := hostKeyCallback(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := callback("192.0.2.1:22", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}, key); err != nil {
+		t.Errorf("expected: pinned key to be accepted | got: %s", err)
+	}
+}
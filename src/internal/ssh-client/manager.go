@@ -0,0 +1,166 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
+)
+
+// keepaliveInterval is how often an idle connection is checked,
+// so a connection dropped by a NAT or firewall is noticed before it is used
+const keepaliveInterval = 30 * time.Second
+
+const keepaliveRequest string = "keepalive@openssh.com"
+
+// Manager keeps one SSH connection per node and shares it between every phase of a command.
+// Close must be called once the command is done with the nodes
+type Manager struct {
+	config types.ConfigFile
+
+	// mu guards the maps, and is never held while dialing so nodes are connected to in parallel
+	mu      sync.Mutex
+	clients map[string]*SSHClient
+	closed  bool
+
+	// nodeLocks serialize connecting to each node, by instance ID
+	nodeLocks map[string]*sync.Mutex
+}
+
+// NewManager creates a connection manager for the cluster described by the config file
+func NewManager(config types.ConfigFile) *Manager {
+	return &Manager{
+		config:    config,
+		clients:   map[string]*SSHClient{},
+		nodeLocks: map[string]*sync.Mutex{},
+	}
+}
+
+// ServerNode returns the cluster's first server, which is the first of the nodes loaded from the stack
+func (m *Manager) ServerNode() (types.Node, error) {
+	if len(m.config.Cluster.Nodes) == 0 {
+		return types.Node{}, errors.New("the cluster's nodes have not been loaded")
+	}
+
+	return m.config.Cluster.Nodes[0], nil
+}
+
+// Server returns the connection to the cluster's server
+func (m *Manager) Server() (*SSHClient, error) {
+	node, err := m.ServerNode()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Connect(node)
+}
+
+// Connect returns the connection to a node, connecting on first use
+// or when the previous connection has been lost
+func (m *Manager) Connect(node types.Node) (*SSHClient, error) {
+	nodeLock := m.nodeLock(node.InstanceID)
+	nodeLock.Lock()
+	defer nodeLock.Unlock()
+
+	m.mu.Lock()
+	client, ok := m.clients[node.InstanceID]
+	m.mu.Unlock()
+
+	if ok {
+		if client.alive() {
+			return client, nil
+		}
+
+		client.Close()
+
+		m.mu.Lock()
+		delete(m.clients, node.InstanceID)
+		m.mu.Unlock()
+	}
+
+	client, err := ConfigureNodeSSHClient(m.config, node)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		client.Close()
+		return nil, errors.New("the connection manager has been closed")
+	}
+
+	go client.keepalive(keepaliveInterval)
+
+	m.clients[node.InstanceID] = client
+
+	return client, nil
+}
+
+// nodeLock returns the lock that serializes connecting to a node
+func (m *Manager) nodeLock(instanceId string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.nodeLocks[instanceId]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.nodeLocks[instanceId] = lock
+	}
+
+	return lock
+}
+
+// Close closes every connection
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+
+	var errs []error
+	for instanceId, client := range m.clients {
+		// Connections lost during the command were already closed by their keepalive
+		if err := client.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+
+		delete(m.clients, instanceId)
+	}
+
+	return errors.Join(errs...)
+}
+
+// alive reports whether the server still answers on the connection
+func (s SSHClient) alive() bool {
+	result := make(chan error, 1)
+
+	go func() {
+		_, _, err := s.conn.SendRequest(keepaliveRequest, true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err == nil
+	case <-time.After(dialAttemptTimeout):
+		return false
+	}
+}
+
+// keepalive sends a request on the connection at every interval until it fails,
+// which happens once the connection is closed or the server stops answering
+func (s SSHClient) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.alive() {
+			s.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// SyncWriter serializes writes so writers shared between goroutines never interleave within a write
+type SyncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewSyncWriter wraps w so it can be written to from several goroutines
+func NewSyncWriter(w io.Writer) *SyncWriter {
+	return &SyncWriter{w: w}
+}
+
+func (s *SyncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
+
+// PrefixWriter writes every line it receives to w with a prefix. Each line is written
+// with a single call, so lines from several PrefixWriters sharing a SyncWriter stay intact
+type PrefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	line   []byte
+}
+
+// NewPrefixWriter returns a writer that prefixes every line written to w
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *PrefixWriter) Write(data []byte) (int, error) {
+	written := len(data)
+
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			p.line = append(p.line, data...)
+			break
+		}
+
+		p.line = append(p.line, data[:i+1]...)
+		data = data[i+1:]
+
+		if err := p.writeLine(); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Flush writes a final line that did not end with a newline
+func (p *PrefixWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+
+	p.line = append(p.line, '\n')
+
+	return p.writeLine()
+}
+
+func (p *PrefixWriter) writeLine() error {
+	line := append(append([]byte{}, p.prefix...), p.line...)
+	p.line = p.line[:0]
+
+	_, err := p.w.Write(line)
+
+	return err
+}
+
+// defaultMaxBuffer is how much of each output stream ExecuteOutput keeps in memory by default
+const defaultMaxBuffer = 1 << 20
+
+// Stdout and Stderr override where Execute streams the output of remote commands.
+// Programs embedding the infra package can set them to route the output into their own loggers.
+// When they are nil, stdout goes to the client's progress writer and stderr to os.Stderr
+var (
+	Stdout io.Writer
+	Stderr io.Writer
+)
+
+// OutputOption configures where ExecuteOutput sends the output of a remote command
+type OutputOption func(*outputConfig)
+
+type outputConfig struct {
+	stdout    []io.Writer
+	stderr    []io.Writer
+	prefix    string
+	logFile   string
+	maxBuffer int
+	streaming bool
+}
+
+// WithStdout streams the command's stdout to w as well as buffering it
+func WithStdout(w io.Writer) OutputOption {
+	return func(c *outputConfig) {
+		c.stdout = append(c.stdout, w)
+	}
+}
+
+// WithStderr streams the command's stderr to w as well as buffering it
+func WithStderr(w io.Writer) OutputOption {
+	return func(c *outputConfig) {
+		c.stderr = append(c.stderr, w)
+	}
+}
+
+// WithStreaming streams the command's output to Stdout and Stderr,
+// or to the client's progress writer and os.Stderr when they are not set
+func WithStreaming() OutputOption {
+	return func(c *outputConfig) {
+		c.streaming = true
+	}
+}
+
+// streamWriter returns the override if one is set, or the fallback
+func streamWriter(override io.Writer, fallback io.Writer) io.Writer {
+	if override != nil {
+		return override
+	}
+
+	return fallback
+}
+
+// WithPrefix prefixes every line streamed to a writer or log file. The buffered output is not prefixed
+func WithPrefix(prefix string) OutputOption {
+	return func(c *outputConfig) {
+		c.prefix = prefix
+	}
+}
+
+// WithLogFile appends the command's stdout and stderr to the file at path, creating it if needed
+func WithLogFile(path string) OutputOption {
+	return func(c *outputConfig) {
+		c.logFile = path
+	}
+}
+
+// WithMaxBuffer sets how many bytes of each output stream are kept in memory.
+// Only the end of the output is kept once it grows larger, and 0 keeps none of it. Defaults to 1 MiB
+func WithMaxBuffer(size int) OutputOption {
+	return func(c *outputConfig) {
+		c.maxBuffer = size
+	}
+}
+
+// outputSinks builds the writers for a command's stdout and stderr from the options.
+// The returned function flushes any partial lines and closes the log file
+func (c outputConfig) outputSinks(stdoutBuffer, stderrBuffer io.Writer) (io.Writer, io.Writer, func() error, error) {
+	stdout := c.stdout
+	stderr := c.stderr
+
+	var logFile *os.File
+	if c.logFile != "" {
+		var err error
+		logFile, err = os.OpenFile(c.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed opening log file: %w", err)
+		}
+
+		stdout = append(stdout, logFile)
+		stderr = append(stderr, logFile)
+	}
+
+	// stdout and stderr are copied concurrently and may share a writer, so writes to the
+	// sinks are serialized. Prefixed lines are written whole, so they never interleave
+	var mu sync.Mutex
+
+	var flushers []*PrefixWriter
+
+	sink := func(writers []io.Writer) io.Writer {
+		var w io.Writer = &lockedWriter{mu: &mu, w: io.MultiWriter(writers...)}
+
+		if c.prefix != "" {
+			prefixWriter := NewPrefixWriter(w, c.prefix)
+			flushers = append(flushers, prefixWriter)
+			w = prefixWriter
+		}
+
+		return w
+	}
+
+	stdoutWriter := stdoutBuffer
+	if len(stdout) > 0 {
+		stdoutWriter = io.MultiWriter(stdoutBuffer, sink(stdout))
+	}
+
+	stderrWriter := stderrBuffer
+	if len(stderr) > 0 {
+		stderrWriter = io.MultiWriter(stderrBuffer, sink(stderr))
+	}
+
+	finish := func() error {
+		var errs []error
+
+		for _, prefixWriter := range flushers {
+			errs = append(errs, prefixWriter.Flush())
+		}
+
+		if logFile != nil {
+			errs = append(errs, logFile.Close())
+		}
+
+		return errors.Join(errs...)
+	}
+
+	return stdoutWriter, stderrWriter, finish, nil
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// cappedBuffer keeps the last max bytes written to it
+type cappedBuffer struct {
+	max  int
+	data []byte
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+
+	// Discard the start of the output in chunks, so large outputs are not copied on every write
+	if len(b.data) > 2*b.max {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.max:]...)
+	}
+
+	return len(p), nil
+}
+
+// Bytes returns the last max bytes written
+func (b *cappedBuffer) Bytes() []byte {
+	if len(b.data) > b.max {
+		return b.data[len(b.data)-b.max:]
+	}
+
+	return b.data
+}
@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewPrefixWriter(&buf, "[server-0] ")
+
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\nunterminated"))
+
+	expected := "[server-0] first line\n[server-0] second line\n"
+	if buf.String() != expected {
+		t.Errorf("expected: %q | got: %q", expected, buf.String())
+	}
+
+	w.Flush()
+
+	expected += "[server-0] unterminated\n"
+	if buf.String() != expected {
+		t.Errorf("expected: %q | got: %q", expected, buf.String())
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	buf := &cappedBuffer{max: 4}
+
+	for _, chunk := range []string{"ab", "cdef", "ghijklmn", "o"} {
+		buf.Write([]byte(chunk))
+	}
+
+	if string(buf.Bytes()) != "lmno" {
+		t.Errorf("expected: %s | got: %s", "lmno", buf.Bytes())
+	}
+}
+
+func TestOutputSinks(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "command.log")
+
+	var stdoutBuffer, stderrBuffer, streamed bytes.Buffer
+
+	config := outputConfig{}
+	for _, opt := range []OutputOption{WithStdout(&streamed), WithPrefix("[server-0] "), WithLogFile(logPath)} {
+		opt(&config)
+	}
+
+	stdout, stderr, finish, err := config.outputSinks(&stdoutBuffer, &stderrBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Write([]byte("installing\ndone"))
+	stderr.Write([]byte("warning\n"))
+
+	if err := finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdoutBuffer.String() != "installing\ndone" {
+		t.Errorf("expected: %q | got: %q", "installing\ndone", stdoutBuffer.String())
+	}
+
+	if streamed.String() != "[server-0] installing\n[server-0] done\n" {
+		t.Errorf("expected: %q | got: %q", "[server-0] installing\n[server-0] done\n", streamed.String())
+	}
+
+	logData, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[server-0] installing\n[server-0] warning\n[server-0] done\n"
+	if string(logData) != expected {
+		t.Errorf("expected: %q | got: %q", expected, logData)
+	}
+}
+
+func TestWithStreaming(t *testing.T) {
+	config := outputConfig{}
+	WithStreaming()(&config)
+
+	if !config.streaming {
+		t.Errorf("expected: streaming | got: %+v", config)
+	}
+
+	// The command's stdout goes to the client's progress writer unless Stdout is set
+	var progress, override bytes.Buffer
+
+	if w := streamWriter(nil, &progress); w != &progress {
+		t.Errorf("expected: %v | got: %v", &progress, w)
+	}
+
+	if w := streamWriter(&override, &progress); w != &override {
+		t.Errorf("expected: %v | got: %v", &override, w)
+	}
+}
@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// defaultTerm is the terminal type requested when $TERM is not set
+const defaultTerm string = "xterm-256color"
+
+// Shell opens an interactive login shell on the remote machine, attached to the local terminal.
+// The local terminal is put in raw mode until the shell exits
+func (s SSHClient) Shell() error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("an interactive shell needs a terminal, pass a command to run it non-interactively")
+	}
+
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		return err
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = defaultTerm
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+
+	if err := sess.RequestPty(termType, height, width, modes); err != nil {
+		return err
+	}
+
+	if err := attachStdio(sess); err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+
+	defer term.Restore(fd, state)
+
+	stopWatching := watchWindowSize(sess, fd)
+	defer stopWatching()
+
+	startTime := time.Now()
+
+	if err := sess.Shell(); err != nil {
+		return err
+	}
+
+	if err := sess.Wait(); err != nil {
+		return newRemoteCommandError("shell", err, nil, nil, time.Since(startTime))
+	}
+
+	return nil
+}
+
+// Run runs a command on the remote machine with its input and output attached to the local stdio
+func (s SSHClient) Run(command string) error {
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+
+	if err := attachStdio(sess); err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+
+	if err := sess.Run(command); err != nil {
+		return newRemoteCommandError(command, err, nil, nil, time.Since(startTime))
+	}
+
+	return nil
+}
+
+// attachStdio connects a session to the local stdio. Stdin is copied by a goroutine
+// that is not waited for, so the session ends as soon as the remote command exits
+// instead of waiting for the next local keystroke
+func attachStdio(sess *ssh.Session) error {
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(stdin, os.Stdin)
+		stdin.Close()
+	}()
+
+	sess.Stdout = os.Stdout
+	sess.Stderr = os.Stderr
+
+	return nil
+}
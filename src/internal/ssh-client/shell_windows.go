@@ -0,0 +1,43 @@
+//go:build windows
+
+package ssh
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// windowSizePollInterval is how often the console size is checked, since Windows has no SIGWINCH
+const windowSizePollInterval = 250 * time.Millisecond
+
+// watchWindowSize resizes the remote terminal whenever the local console is resized.
+// The returned function stops watching
+func watchWindowSize(sess *ssh.Session, fd int) func() {
+	done := make(chan struct{})
+
+	go func() {
+		width, height, _ := term.GetSize(fd)
+
+		ticker := time.NewTicker(windowSizePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				newWidth, newHeight, err := term.GetSize(fd)
+				if err != nil || (newWidth == width && newHeight == height) {
+					continue
+				}
+
+				width, height = newWidth, newHeight
+				sess.WindowChange(height, width)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -1,12 +1,17 @@
 package ssh
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"os"
-	"sync"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/lucasrod16/ec2-k3s/src/internal/utils"
+	"github.com/lucasrod16/ec2-k3s/src/internal/types"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -15,15 +20,33 @@ const (
 	ec2User string = "ubuntu"
 )
 
+// Dial retry settings. sshd may not accept connections, and cloud-init may not have
+// installed the authorized key yet, for a while after the instance passes its status checks
+const (
+	dialAttemptTimeout = 10 * time.Second
+	dialDeadline       = 5 * time.Minute
+	dialInitialBackoff = 1 * time.Second
+	dialMaxBackoff     = 30 * time.Second
+
+	// dialAuthAttempts is how many attempts may fail authentication, which covers the
+	// first seconds after sshd starts before cloud-init installs the key. A wrong key fails after them
+	dialAuthAttempts = 5
+)
+
 // SSHClient initializes a ssh client connection
 type SSHClient struct {
 	conn *ssh.Client
+
+	// progress receives connection retries and the streamed output of remote commands
+	progress io.Writer
 }
 
 // ExecuteCommand executes a command on a remote machine to install k3s
 type ExecuteCommand interface {
 	Execute(command string) (CommandOutput, error)
-	ExecuteOutput(command string, stream bool) (CommandOutput, error)
+	ExecuteOutput(command string, opts ...OutputOption) (CommandOutput, error)
+	Upload(ctx context.Context, src io.Reader, remotePath string, mode os.FileMode) error
+	Download(ctx context.Context, remotePath string, dst io.Writer) error
 }
 
 // CommandOutput contains the STDIO output from running a command
@@ -33,128 +56,213 @@ type CommandOutput struct {
 }
 
 // NewSSHClient creates a new ssh client connection
-// with the provdided host and configuration
-func NewSSHClient(host string, config *ssh.ClientConfig) (*SSHClient, error) {
-	conn, err := ssh.Dial("tcp", host, config)
+// with the provdided host and configuration.
+// Connection failures that are expected while the instance boots are retried with backoff,
+// and each retry is reported to progress
+func NewSSHClient(host string, config *ssh.ClientConfig, progress io.Writer) (*SSHClient, error) {
+	if progress == nil {
+		progress = os.Stdout
+	}
+
+	startTime := time.Now()
+	backoff := dialInitialBackoff
+	authFailures := 0
+
+	for attempt := 1; ; attempt++ {
+		conn, err := dial(host, config)
+		if err == nil {
+			return &SSHClient{conn: conn, progress: progress}, nil
+		}
+
+		if isAuthError(err) {
+			authFailures++
+		}
+
+		if !isRetryableDialError(err, authFailures) {
+			if isAuthError(err) {
+				return nil, fmt.Errorf("authentication to %s failed %d times, check that the ssh key is the one the cluster was created with: %w", host, authFailures, err)
+			}
+
+			return nil, err
+		}
+
+		if time.Since(startTime)+backoff >= dialDeadline {
+			return nil, fmt.Errorf("gave up connecting to %s after %d attempts: %w", host, attempt, err)
+		}
+
+		fmt.Fprintf(progress, "SSH connection to %s failed (attempt %d): %s, retrying in %s\n", host, attempt, err, backoff)
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > dialMaxBackoff {
+			backoff = dialMaxBackoff
+		}
+	}
+}
+
+// dial makes a single connection attempt. The timeout covers the SSH handshake
+// as well as the TCP connection, so a server that accepts but never answers is abandoned
+func dial(host string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	netConn, err := net.DialTimeout("tcp", host, dialAttemptTimeout)
 	if err != nil {
 		return nil, err
 	}
 
-	client := SSHClient{
-		conn: conn,
+	if err := netConn.SetDeadline(time.Now().Add(dialAttemptTimeout)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(netConn, host, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	// Commands may run for much longer than the handshake
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	return &client, nil
+	return ssh.NewClient(conn, chans, reqs), nil
 }
 
-// ExecuteOutput pipes the remote command output to local stdio
-func (s SSHClient) ExecuteOutput(command string, stream bool) (CommandOutput, error) {
-	sess, err := s.conn.NewSession()
-	if err != nil {
-		return CommandOutput{}, err
+// isRetryableDialError reports whether a connection failure is expected while the instance boots.
+// Authentication failures are only retried until authFailures of them have happened, since a wrong key
+// never succeeds. Any other failure, such as a host key mismatch, is returned immediately
+func isRetryableDialError(err error, authFailures int) bool {
+	if isAuthError(err) {
+		return authFailures < dialAuthAttempts
 	}
 
-	defer sess.Close()
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return true
+	}
 
-	sessStdOut, err := sess.StdoutPipe()
-	if err != nil {
-		return CommandOutput{}, err
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
 	}
 
-	output := bytes.Buffer{}
-	wg := sync.WaitGroup{}
+	// Handshake errors are only available as strings
+	message := err.Error()
+	for _, retryable := range []string{
+		// sshd closed the connection while it was starting up
+		"handshake failed: EOF",
+		"connection reset by peer",
+		"i/o timeout",
+	} {
+		if strings.Contains(message, retryable) {
+			return true
+		}
+	}
 
-	var stdOutWriter io.Writer
-	if stream {
-		stdOutWriter = io.MultiWriter(os.Stdout, &output)
-	} else {
-		stdOutWriter = &output
+	return false
+}
+
+// isAuthError reports whether the server rejected the key, which is expected until cloud-init has installed it
+func isAuthError(err error) bool {
+	// Handshake errors are only available as strings
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// ExecuteOutput runs a remote command and returns its output. By default the output is only
+// buffered, options stream it to other writers and a log file and set how much of it is buffered.
+// If the command fails, the output captured so far is returned with a *RemoteCommandError
+func (s SSHClient) ExecuteOutput(command string, opts ...OutputOption) (CommandOutput, error) {
+	config := outputConfig{maxBuffer: defaultMaxBuffer}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if config.maxBuffer < 0 {
+		config.maxBuffer = 0
+	}
+
+	if config.streaming {
+		config.stdout = append(config.stdout, streamWriter(Stdout, s.progress))
+		config.stderr = append(config.stderr, streamWriter(Stderr, os.Stderr))
 	}
 
-	wg.Add(1)
-	go func() {
-		io.Copy(stdOutWriter, sessStdOut)
-		wg.Done()
-	}()
+	output := &cappedBuffer{max: config.maxBuffer}
+	errorOutput := &cappedBuffer{max: config.maxBuffer}
 
-	sessStderr, err := sess.StderrPipe()
+	stdout, stderr, finish, err := config.outputSinks(output, errorOutput)
 	if err != nil {
 		return CommandOutput{}, err
 	}
 
-	errorOutput := bytes.Buffer{}
-	var stdErrWriter io.Writer
-	if stream {
-		stdErrWriter = io.MultiWriter(os.Stderr, &errorOutput)
-	} else {
-		stdErrWriter = &errorOutput
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		finish()
+		return CommandOutput{}, err
 	}
 
-	wg.Add(1)
-	go func() {
-		io.Copy(stdErrWriter, sessStderr)
-		wg.Done()
-	}()
+	defer sess.Close()
+
+	// Run waits for the output to be copied even if the command failed, so none of it is lost
+	sess.Stdout = stdout
+	sess.Stderr = stderr
+
+	startTime := time.Now()
 
 	err = sess.Run(command)
-	if err != nil {
-		return CommandOutput{}, err
-	}
 
-	wg.Wait()
+	if finishErr := finish(); err == nil && finishErr != nil {
+		err = finishErr
+	}
 
-	return CommandOutput{
+	commandOutput := CommandOutput{
 		StdErr: errorOutput.Bytes(),
 		StdOut: output.Bytes(),
-	}, nil
+	}
+
+	if err != nil {
+		return commandOutput, newRemoteCommandError(command, err, commandOutput.StdOut, commandOutput.StdErr, time.Since(startTime))
+	}
+
+	return commandOutput, nil
 }
 
+// Execute runs a remote command with its output streamed as it runs
 func (s SSHClient) Execute(command string) (CommandOutput, error) {
-	return s.ExecuteOutput(command, true)
+	return s.ExecuteOutput(command, WithStreaming())
 }
 
 func (s SSHClient) Close() error {
 	return s.conn.Close()
 }
 
-// ConfigureSSHClient configures a ssh client
-// with a user, host, and ssh keys
-func ConfigureSSHClient(region string) (*SSHClient, error) {
-	privateKey := utils.GetPrivateSSHKey()
-
-	signer, err := ssh.ParsePrivateKey(privateKey)
+// ConfigureNodeSSHClient configures a ssh client connected to a node,
+// verifying that the node presents its pinned host key
+func ConfigureNodeSSHClient(config types.ConfigFile, node types.Node) (*SSHClient, error) {
+	auth, closeAgent, err := authMethod(config.SSH)
 	if err != nil {
 		return nil, err
 	}
 
-	config := &ssh.ClientConfig{
-		User: ec2User,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
+	// The agent is only needed to sign the handshake
+	defer closeAgent()
 
-	host, err := getHost(region)
+	verifyHostKey, err := hostKeyCallback(node)
 	if err != nil {
 		return nil, err
 	}
 
-	sshClient, err := NewSSHClient(host, config)
-	if err != nil {
-		return nil, err
+	clientConfig := &ssh.ClientConfig{
+		User:            ec2User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: verifyHostKey,
 	}
 
-	return sshClient, nil
-}
+	host := net.JoinHostPort(node.IP, sshPort)
 
-func getHost(region string) (string, error) {
-	ip, err := utils.GetInstanceIp(region)
+	sshClient, err := NewSSHClient(host, clientConfig, config.ProgressWriter())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	host := ip + ":" + sshPort
-
-	return host, nil
+	return sshClient, nil
 }
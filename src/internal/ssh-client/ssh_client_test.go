@@ -0,0 +1,71 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestIsRetryableDialError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "connection refused",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			expected: true,
+		},
+		{
+			name:     "key not installed yet",
+			err:      fmt.Errorf("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"),
+			expected: true,
+		},
+		{
+			name:     "sshd starting",
+			err:      fmt.Errorf("ssh: handshake failed: EOF"),
+			expected: true,
+		},
+		{
+			name:     "host key mismatch",
+			err:      fmt.Errorf("ssh: handshake failed: host key mismatch for 192.0.2.1:22 (i-0123456789abcdef0)"),
+			expected: false,
+		},
+		{
+			name:     "unknown host",
+			err:      errors.New("dial tcp: lookup example.invalid: no such host"),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		got := isRetryableDialError(tt.err, 1)
+		if got != tt.expected {
+			t.Errorf("%s: expected: %t | got: %t", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestIsRetryableDialErrorAuth tests that authentication failures are only retried a few times
+func TestIsRetryableDialErrorAuth(t *testing.T) {
+	err := fmt.Errorf("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain")
+
+	for failures := 1; failures < dialAuthAttempts; failures++ {
+		if !isRetryableDialError(err, failures) {
+			t.Errorf("%d failures: expected: %t | got: %t", failures, true, false)
+		}
+	}
+
+	if isRetryableDialError(err, dialAuthAttempts) {
+		t.Errorf("%d failures: expected: %t | got: %t", dialAuthAttempts, false, true)
+	}
+
+	// Connection failures are retried until the dial deadline
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	if !isRetryableDialError(refused, dialAuthAttempts+10) {
+		t.Errorf("expected: connection refused to be retried | got: %t", false)
+	}
+}
@@ -0,0 +1,415 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpServerPath is where Ubuntu installs the OpenSSH SFTP server.
+// It is run through sudo so files owned by root can be read and written
+const sftpServerPath string = "/usr/lib/openssh/sftp-server"
+
+// errSFTPUnavailable is returned when the SFTP server cannot be started, in which case scp is used
+var errSFTPUnavailable = errors.New("sftp server unavailable")
+
+// ShellQuote quotes a value so it is passed to a remote command as a single argument
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// tempPath returns a unique temporary file name next to remotePath
+func tempPath(remotePath string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return remotePath + ".tmp-" + hex.EncodeToString(suffix), nil
+}
+
+// Upload copies the contents of src to a root-owned file on the remote machine,
+// creating any missing parent directories
+func (s SSHClient) Upload(ctx context.Context, src io.Reader, remotePath string, mode os.FileMode) error {
+	err := s.sftpUpload(ctx, src, remotePath, mode)
+	if errors.Is(err, errSFTPUnavailable) {
+		err = s.scpUpload(ctx, src, remotePath, mode)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed uploading %s: %w", remotePath, err)
+	}
+
+	return nil
+}
+
+// Download copies the contents of a file on the remote machine, which may be owned by root, to dst
+func (s SSHClient) Download(ctx context.Context, remotePath string, dst io.Writer) error {
+	err := s.sftpDownload(ctx, remotePath, dst)
+	if errors.Is(err, errSFTPUnavailable) {
+		err = s.scpDownload(ctx, remotePath, dst)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed downloading %s: %w", remotePath, err)
+	}
+
+	return nil
+}
+
+// startSFTP starts an SFTP server with root privileges and returns a client for it.
+// The returned function closes the client and its session
+func (s SSHClient) startSFTP(ctx context.Context) (*sftp.Client, func(), error) {
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+
+	if err := sess.Start("sudo " + sftpServerPath); err != nil {
+		sess.Close()
+		return nil, nil, fmt.Errorf("%w: %s", errSFTPUnavailable, err)
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		sess.Close()
+		return nil, nil, fmt.Errorf("%w: %s", errSFTPUnavailable, err)
+	}
+
+	stopWatching := closeOnCancel(ctx, sess)
+
+	return client, func() {
+		stopWatching()
+		client.Close()
+		sess.Close()
+	}, nil
+}
+
+// sftpUpload writes to a temporary file that replaces the destination,
+// so a program reading the file never sees a partial upload
+func (s SSHClient) sftpUpload(ctx context.Context, src io.Reader, remotePath string, mode os.FileMode) error {
+	client, closeSFTP, err := s.startSFTP(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer closeSFTP()
+
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return contextError(ctx, err)
+	}
+
+	// A unique name keeps concurrent uploads of the same file from writing to each other's temporary file
+	tmpPath, err := tempPath(remotePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	if err := writeSFTPFile(file, src, mode); err != nil {
+		client.Remove(tmpPath)
+		return contextError(ctx, err)
+	}
+
+	if err := client.PosixRename(tmpPath, remotePath); err != nil {
+		client.Remove(tmpPath)
+		return contextError(ctx, err)
+	}
+
+	return nil
+}
+
+// writeSFTPFile sets the mode of a file and writes src to it, closing it in any case
+func writeSFTPFile(file *sftp.File, src io.Reader, mode os.FileMode) error {
+	if err := file.Chmod(mode.Perm()); err != nil {
+		file.Close()
+		return err
+	}
+
+	if _, err := file.ReadFrom(src); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s SSHClient) sftpDownload(ctx context.Context, remotePath string, dst io.Writer) error {
+	client, closeSFTP, err := s.startSFTP(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer closeSFTP()
+
+	file, err := client.Open(remotePath)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	defer file.Close()
+
+	_, err = file.WriteTo(dst)
+
+	return contextError(ctx, err)
+}
+
+// scpUpload sends a file with the sink side of the scp protocol. Like sftpUpload, it writes to a
+// temporary file that replaces the destination, which also allows replacing a running binary
+func (s SSHClient) scpUpload(ctx context.Context, src io.Reader, remotePath string, mode os.FileMode) error {
+	// scp needs to know the size of the file up front
+	size, src, err := readerSize(src)
+	if err != nil {
+		return err
+	}
+
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+	defer closeOnCancel(ctx, sess)()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	tmpPath, err := tempPath(remotePath)
+	if err != nil {
+		return err
+	}
+
+	scpCommand := scpUploadCommand(tmpPath, remotePath, mode)
+
+	if err := sess.Start(scpCommand); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(stdout)
+
+	if err := readSCPAck(reader); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", mode.Perm(), size, path.Base(tmpPath)); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if err := readSCPAck(reader); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if _, err := io.CopyN(stdin, src, size); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if err := readSCPAck(reader); err != nil {
+		return contextError(ctx, err)
+	}
+
+	stdin.Close()
+
+	return contextError(ctx, sess.Wait())
+}
+
+// scpUploadCommand returns the command that receives a file into tmpPath and moves it to remotePath.
+// scp only applies the mode to new files, so it is set explicitly, and the temporary file is removed
+// if any step fails
+func scpUploadCommand(tmpPath, remotePath string, mode os.FileMode) string {
+	return fmt.Sprintf(
+		"sudo mkdir -p %s && { sudo scp -t %s && sudo chmod %o %s && sudo mv -f %s %s || { sudo rm -f %s; exit 1; }; }",
+		ShellQuote(path.Dir(remotePath)),
+		ShellQuote(tmpPath),
+		mode.Perm(), ShellQuote(tmpPath),
+		ShellQuote(tmpPath), ShellQuote(remotePath),
+		ShellQuote(tmpPath),
+	)
+}
+
+// scpDownload receives a file with the source side of the scp protocol
+func (s SSHClient) scpDownload(ctx context.Context, remotePath string, dst io.Writer) error {
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+	defer closeOnCancel(ctx, sess)()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := sess.Start("sudo scp -f " + ShellQuote(remotePath)); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(stdout)
+
+	// Each message from the source is answered with a null byte
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return contextError(ctx, err)
+	}
+
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	size, err := parseSCPHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if _, err := io.CopyN(dst, reader, size); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if err := readSCPAck(reader); err != nil {
+		return contextError(ctx, err)
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return contextError(ctx, err)
+	}
+
+	stdin.Close()
+
+	return contextError(ctx, sess.Wait())
+}
+
+// readSCPAck reads the response to an scp message, which is a null byte on success
+// or a 1 (warning) or 2 (error) byte followed by a message
+func readSCPAck(reader *bufio.Reader) error {
+	code, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if code == 0 {
+		return nil
+	}
+
+	message, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("scp: %s", strings.TrimSpace(message))
+}
+
+// parseSCPHeader returns the file size from an scp file header, which looks like: C0644 1234 k3s.yaml
+func parseSCPHeader(header string) (int64, error) {
+	if header != "" && (header[0] == 1 || header[0] == 2) {
+		return 0, fmt.Errorf("scp: %s", strings.TrimSpace(header[1:]))
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+		return 0, fmt.Errorf("scp: unexpected file header %q", header)
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("scp: unexpected file size in header %q", header)
+	}
+
+	return size, nil
+}
+
+// readerSize returns the number of bytes a reader will produce. Files report their size,
+// any other reader is read into memory. The returned reader must be used in place of src
+func readerSize(src io.Reader) (int64, io.Reader, error) {
+	if file, ok := src.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return info.Size() - offset, file, nil
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return int64(len(data)), bytes.NewReader(data), nil
+}
+
+// closeOnCancel closes c if the context is cancelled before the returned function is called
+func closeOnCancel(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// contextError returns the context's error in place of the error caused by closing the connection
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
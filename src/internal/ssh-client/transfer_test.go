@@ -0,0 +1,82 @@
+package ssh
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseSCPHeader(t *testing.T) {
+	size, err := parseSCPHeader("C0600 2957 k3s.yaml\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 2957 {
+		t.Errorf("expected: %d | got: %d", 2957, size)
+	}
+
+	for _, header := range []string{"\x01scp: /etc/rancher/k3s/k3s.yaml: No such file or directory\n", "D0755 0 certs\n", "C0600 many k3s.yaml\n"} {
+		if _, err := parseSCPHeader(header); err == nil {
+			t.Errorf("expected: error for header %q | got: nil", header)
+		}
+	}
+}
+
+func TestReadSCPAck(t *testing.T) {
+	if err := readSCPAck(bufio.NewReader(strings.NewReader("\x00"))); err != nil {
+		t.Errorf("expected: nil | got: %s", err)
+	}
+
+	err := readSCPAck(bufio.NewReader(strings.NewReader("\x02scp: /usr/local/bin: Permission denied\n")))
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("expected: Permission denied | got: %v", err)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"/etc/rancher/k3s/k3s.yaml": `'/etc/rancher/k3s/k3s.yaml'`,
+		"/tmp/my file":              `'/tmp/my file'`,
+		"it's; rm -rf /":            `'it'\''s; rm -rf /'`,
+		"":                          `''`,
+	}
+
+	for value, expected := range tests {
+		if quoted := ShellQuote(value); quoted != expected {
+			t.Errorf("expected: %s | got: %s", expected, quoted)
+		}
+	}
+}
+
+func TestTempPath(t *testing.T) {
+	first, err := tempPath("/etc/rancher/k3s/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := tempPath("/etc/rancher/k3s/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(first, "/etc/rancher/k3s/config.yaml.tmp-") {
+		t.Errorf("expected: %s | got: %s", "/etc/rancher/k3s/config.yaml.tmp-<suffix>", first)
+	}
+
+	if first == second {
+		t.Errorf("expected: unique temporary paths | got: %s twice", first)
+	}
+}
+
+// TestSCPUploadCommand tests that scp writes to the temporary file, which is moved into place or removed
+func TestSCPUploadCommand(t *testing.T) {
+	command := scpUploadCommand("/usr/local/bin/k3s.tmp-0123", "/usr/local/bin/k3s", 0755)
+
+	expected := "sudo mkdir -p '/usr/local/bin' && { sudo scp -t '/usr/local/bin/k3s.tmp-0123' && sudo chmod 755 '/usr/local/bin/k3s.tmp-0123' && " +
+		"sudo mv -f '/usr/local/bin/k3s.tmp-0123' '/usr/local/bin/k3s' || { sudo rm -f '/usr/local/bin/k3s.tmp-0123'; exit 1; }; }"
+
+	if command != expected {
+		t.Errorf("expected: %s | got: %s", expected, command)
+	}
+}
@@ -1,17 +1,202 @@
 package types
 
 import (
+	"io"
+	"os"
+	"time"
+
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
 )
 
 type Infrastructure struct {
-	Ami           *ec2.LookupAmiResult
-	Keypair       *ec2.KeyPair
-	SecurityGroup *ec2.SecurityGroup
-	Server        *ec2.Instance
+	Ami             *ec2.LookupAmiResult
+	Keypair         *ec2.KeyPair
+	SecurityGroup   *ec2.SecurityGroup
+	Server          *ec2.Instance
+	SnapshotBucket  *s3.BucketV2
+	InstanceProfile *iam.InstanceProfile
+}
+
+// Node is an ec2 instance that is part of the k3s cluster
+type Node struct {
+	// Name identifies the node in ec2-k3s output, e.g. server-0
+	Name string
+
+	// Role is either "server" or "agent"
+	Role string
+
+	// InstanceID is the ID of the node's ec2 instance
+	InstanceID string
+
+	IP string
+
+	// HostKeys are the node's SSH host keys in authorized_keys format, pinned in the Pulumi stack
+	HostKeys []byte
 }
 
 type ConfigFile struct {
+	// Name identifies the cluster's AWS resources and kubeconfig context. It defaults to <local user>-dev
+	// and is stored in the Pulumi stack when the cluster is created, so it is the same on every machine
+	Name string `json:"name" yaml:"name"`
+
 	Region       string `json:"region" yaml:"region"`
 	InstanceType string `json:"instanceType" yaml:"instanceType"`
+	K3s          K3s    `json:"k3s" yaml:"k3s"`
+
+	// Components enables or disables the k3s packaged components by name
+	Components map[string]bool `json:"components" yaml:"components"`
+
+	// Registries configures the container registries k3s pulls images from
+	Registries Registries `json:"registries" yaml:"registries"`
+
+	// Snapshots configures etcd snapshots of the cluster state
+	Snapshots Snapshots `json:"snapshots" yaml:"snapshots"`
+
+	// ReadinessTimeout is how long to wait for the cluster to be ready after k3s is installed, e.g. 5m
+	ReadinessTimeout time.Duration `json:"readinessTimeout" yaml:"readinessTimeout"`
+
+	// SSH configures the key used to connect to the ec2 instance
+	SSH SSH `json:"ssh" yaml:"ssh"`
+
+	// Cluster is the state of the provisioned cluster, loaded from the Pulumi stack at runtime
+	Cluster Cluster `json:"-" yaml:"-"`
+
+	// Progress receives progress output. It is set to stderr when a command writes its result to stdout
+	Progress io.Writer `json:"-" yaml:"-"`
+}
+
+// ProgressWriter returns where progress output is written, which is stdout unless Progress is set
+func (c ConfigFile) ProgressWriter() io.Writer {
+	if c.Progress != nil {
+		return c.Progress
+	}
+
+	return os.Stdout
+}
+
+// Cluster is the state of a provisioned cluster
+type Cluster struct {
+	// Name is the name the cluster was created with
+	Name string
+
+	// Nodes are the cluster's nodes, servers first and then agents
+	Nodes []Node
+}
+
+// K3s contains the settings used to install k3s on the ec2 instance
+type K3s struct {
+	// Version is the k3s release to install, e.g. v1.27.1+k3s1.
+	// The latest stable release is used when it is empty
+	Version string `json:"version" yaml:"version"`
+
+	// Airgap installs k3s from artifacts uploaded over SSH
+	// instead of having the instance download them
+	Airgap bool `json:"airgap" yaml:"airgap"`
+
+	// Config is rendered to /etc/rancher/k3s/config.yaml on the ec2 instance.
+	// Keys are k3s server flags without the leading dashes, e.g. cluster-cidr
+	Config map[string]interface{} `json:"config" yaml:"config"`
+
+	// Token is the cluster's k3s token, loaded from the Pulumi stack at runtime
+	Token string `json:"-" yaml:"-"`
+}
+
+// KubeconfigOptions controls where the cluster's kubeconfig is written
+type KubeconfigOptions struct {
+	// Merge adds the cluster to $KUBECONFIG or ~/.kube/config under a context named after the cluster
+	Merge bool
+
+	// SwitchContext makes the merged context the current context
+	SwitchContext bool
+
+	// Out is where the kubeconfig is written: a file path, "-" for stdout,
+	// or an ssm://<parameter name> or secretsmanager://<secret name> URI
+	Out string
+
+	// Stdout receives the kubeconfig when Out is "-"
+	Stdout io.Writer
+}
+
+// Forward is a local address whose connections are proxied to an address reached from the ec2 instance
+type Forward struct {
+	LocalAddr  string
+	RemoteAddr string
+}
+
+// TunnelOptions controls what the tunnel command forwards over the SSH connection
+type TunnelOptions struct {
+	Forwards []Forward
+
+	// SOCKSAddr is the local address of a SOCKS5 proxy, or empty for no proxy
+	SOCKSAddr string
+
+	// KubeconfigOut is where a kubeconfig that reaches the API server through the tunnel is written
+	KubeconfigOut string
+}
+
+// SSH configures the SSH key pair. Paths may start with ~/ to refer to the home directory
+type SSH struct {
+	// PublicKeyPath is the public key installed on the ec2 instance.
+	// It defaults to the private key path with a .pub suffix
+	PublicKeyPath string `json:"publicKeyPath" yaml:"publicKeyPath"`
+
+	// PrivateKeyPath defaults to the first of ~/.ssh/id_ed25519 and ~/.ssh/id_rsa that exists
+	PrivateKeyPath string `json:"privateKeyPath" yaml:"privateKeyPath"`
+
+	// Ephemeral generates an ed25519 key pair for the cluster instead of using a local key.
+	// The private key is stored as a Pulumi stack output encrypted by the stack's secrets provider
+	Ephemeral bool `json:"ephemeral" yaml:"ephemeral"`
+
+	// PrivateKey is the cluster's ephemeral private key, loaded from the Pulumi stack at runtime
+	PrivateKey []byte `json:"-" yaml:"-"`
+}
+
+// Snapshots configures etcd snapshots
+type Snapshots struct {
+	// Enabled runs k3s with embedded etcd, which is required to take snapshots
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// S3 provisions an S3 bucket that snapshots are uploaded to so they survive "down".
+	// It implies Enabled
+	S3 bool `json:"s3" yaml:"s3"`
+}
+
+// Registries contains registry mirrors and per-registry credentials and TLS settings.
+// It is rendered to /etc/rancher/k3s/registries.yaml on the ec2 instance
+type Registries struct {
+	// Mirrors is keyed by the registry hostname that image references use, e.g. docker.io
+	Mirrors map[string]RegistryMirror `json:"mirrors" yaml:"mirrors"`
+
+	// Configs is keyed by the registry hostname that is connected to
+	Configs map[string]RegistryConfig `json:"configs" yaml:"configs"`
+}
+
+// RegistryMirror is a list of endpoints that images are pulled from instead of the registry
+type RegistryMirror struct {
+	Endpoints []string          `json:"endpoints" yaml:"endpoints"`
+	Rewrites  map[string]string `json:"rewrites" yaml:"rewrites"`
+}
+
+// RegistryConfig contains the credentials and TLS settings used to connect to a registry
+type RegistryConfig struct {
+	Auth RegistryAuth `json:"auth" yaml:"auth"`
+	TLS  RegistryTLS  `json:"tls" yaml:"tls"`
+}
+
+// RegistryAuth contains registry credentials
+type RegistryAuth struct {
+	Username      string `json:"username" yaml:"username"`
+	Password      string `json:"password" yaml:"password"`
+	Auth          string `json:"auth" yaml:"auth"`
+	IdentityToken string `json:"identityToken" yaml:"identityToken"`
+}
+
+// RegistryTLS contains paths to files on the workstation that are uploaded to the ec2 instance
+type RegistryTLS struct {
+	CAFile             string `json:"caFile" yaml:"caFile"`
+	CertFile           string `json:"certFile" yaml:"certFile"`
+	KeyFile            string `json:"keyFile" yaml:"keyFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
 }
@@ -2,63 +2,34 @@ package utils
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/user"
-	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/google/uuid"
 )
 
-const (
-	publicKeyFile  string = ".ssh/id_rsa.pub"
-	privateKeyFile string = ".ssh/id_rsa"
-)
-
 var (
 	InstanceOwner string = createInstanceOwnerTag()
 )
 
-// GetPublicSSHKey returns the public ssh key at ~/.ssh/id_rsa.pub
-func GetPublicSSHKey() []byte {
-	userHomeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	publicSSHKey := path.Join(userHomeDir, publicKeyFile)
-	keyData, err := os.ReadFile(publicSSHKey)
-	if err != nil {
-		log.Fatalf("Failed reading data from public ssh key: %s", err)
-	}
-
-	return keyData
-}
-
-// GetPrivateSSHKey returns the private ssh key at ~/.ssh/id_rsa
-func GetPrivateSSHKey() []byte {
-	userHomeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	privateSSHKey := path.Join(userHomeDir, privateKeyFile)
-	keyData, err := os.ReadFile(privateSSHKey)
-	if err != nil {
-		log.Fatalf("Failed reading data from private ssh key: %s", err)
-	}
-
-	return keyData
-}
-
-// LocalIP returns the IP address of the machine that executed the program
-func LocalIP() []byte {
+// LocalIP returns the IP address of the machine that executed the program and reports it to progress
+func LocalIP(progress io.Writer) []byte {
 	resp, err := http.Get("https://checkip.amazonaws.com")
 	if err != nil {
 		log.Fatal(err)
@@ -75,7 +46,7 @@ func LocalIP() []byte {
 	suffix := "/32"
 	cidr := append([]byte(trimmedBody), suffix...)
 
-	fmt.Printf("\nWorkstation IP address: %s", cidr)
+	fmt.Fprintf(progress, "\nWorkstation IP address: %s", cidr)
 
 	return cidr
 }
@@ -88,14 +59,119 @@ func SetupEC2Client(region string) *ec2.EC2 {
 	return svc
 }
 
-// GetInstanceStatus returns the reachability status of the ec2 instance
-func GetInstanceStatus(region string) (string, error) {
-	client := SetupEC2Client(region)
-	instanceId, err := getInstanceId(region)
+// GetAccountId returns the ID of the AWS account the credentials belong to
+func GetAccountId(region string) (string, error) {
+	sess := session.Must(session.NewSession())
+	svc := sts.New(sess, aws.NewConfig().WithRegion(region))
+
+	result, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	if err != nil {
 		return "", err
 	}
 
+	return aws.StringValue(result.Account), nil
+}
+
+// BucketExists returns whether an S3 bucket exists and is accessible
+func BucketExists(region, bucket string) (bool, error) {
+	sess := session.Must(session.NewSession())
+	svc := s3.New(sess, aws.NewConfig().WithRegion(region))
+
+	_, err := svc.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetObject returns the contents of an S3 object, or nil if the object or its bucket does not exist
+func GetObject(region, bucket, key string) ([]byte, error) {
+	sess := session.Must(session.NewSession())
+	svc := s3.New(sess, aws.NewConfig().WithRegion(region))
+
+	output, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == s3.ErrCodeNoSuchBucket) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
+
+// PutObject writes an S3 object encrypted at rest, overwriting any existing object
+func PutObject(region, bucket, key string, data []byte) error {
+	sess := session.Must(session.NewSession())
+	svc := s3.New(sess, aws.NewConfig().WithRegion(region))
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(data),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+
+	return err
+}
+
+// PutSSMParameter stores a value as an encrypted SSM parameter, overwriting any existing value
+func PutSSMParameter(region, name string, value []byte) error {
+	sess := session.Must(session.NewSession())
+	svc := ssm.New(sess, aws.NewConfig().WithRegion(region))
+
+	_, err := svc.PutParameter(&ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(string(value)),
+		Type:      aws.String(ssm.ParameterTypeSecureString),
+		Overwrite: aws.Bool(true),
+		// Values over the standard tier's 4 KB limit are stored in the advanced tier
+		Tier: aws.String(ssm.ParameterTierIntelligentTiering),
+	})
+
+	return err
+}
+
+// PutSecret stores a value in Secrets Manager, creating the secret if it does not exist
+func PutSecret(region, name string, value []byte) error {
+	sess := session.Must(session.NewSession())
+	svc := secretsmanager.New(sess, aws.NewConfig().WithRegion(region))
+
+	_, err := svc.PutSecretValue(&secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(name),
+		SecretString: aws.String(string(value)),
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		_, err = svc.CreateSecret(&secretsmanager.CreateSecretInput{
+			Name:         aws.String(name),
+			SecretString: aws.String(string(value)),
+		})
+	}
+
+	return err
+}
+
+// GetInstanceStatus returns the reachability status of an ec2 instance
+func GetInstanceStatus(region, instanceId string) (string, error) {
+	client := SetupEC2Client(region)
+
 	input := &ec2.DescribeInstanceStatusInput{
 		InstanceIds: []*string{
 			aws.String(instanceId),
@@ -115,56 +191,39 @@ func GetInstanceStatus(region string) (string, error) {
 	return instanceStatus, nil
 }
 
-// GetInstanceIp returns the public IP address of the ec2 instance
-func GetInstanceIp(region string) (string, error) {
+// GetConsoleOutput returns the most recent console output of an ec2 instance,
+// or an empty string if the instance has not produced any output yet
+func GetConsoleOutput(region, instanceId string) (string, error) {
 	client := SetupEC2Client(region)
-	instanceId, err := getInstanceId(region)
-	if err != nil {
-		return "", err
-	}
 
-	input := &ec2.DescribeInstancesInput{
-		InstanceIds: []*string{
-			aws.String(instanceId),
-		},
+	result, err := client.GetConsoleOutput(&ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceId),
+		Latest:     aws.Bool(true),
+	})
+
+	// Only Nitro instances can return the latest output, others return the output captured after boot
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "UnsupportedOperation" {
+		result, err = client.GetConsoleOutput(&ec2.GetConsoleOutputInput{
+			InstanceId: aws.String(instanceId),
+		})
 	}
 
-	// Get the IP address of the EC2 instance
-	result, err := client.DescribeInstances(input)
 	if err != nil {
 		return "", err
 	}
 
-	// Convert string pointer to string
-	publicIpAddressPointer := result.Reservations[0].Instances[0].PublicIpAddress
-	publicIpAddress := aws.StringValue(publicIpAddressPointer)
-
-	return publicIpAddress, nil
-}
-
-func getInstanceId(region string) (string, error) {
-	client := SetupEC2Client(region)
-	input := &ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:Owner"),
-				Values: []*string{
-					aws.String(InstanceOwner),
-				},
-			},
-		},
-	}
-
-	// Get the instance ID of the EC2 instance
-	result, err := client.DescribeInstances(input)
+	output, err := base64.StdEncoding.DecodeString(aws.StringValue(result.Output))
 	if err != nil {
 		return "", err
 	}
 
-	instanceIdPointer := result.Reservations[0].Instances[0].InstanceId
-	instanceId := aws.StringValue(instanceIdPointer)
+	return string(output), nil
+}
 
-	return instanceId, nil
+// GetInstanceName returns the default cluster name, which names the ec2 instance and its resources
+func GetInstanceName() string {
+	return GetCurrentUser() + "-dev"
 }
 
 // createInstanceOwnerTag creates a unique name for the ec2 instance owner tag value
@@ -184,3 +243,17 @@ func GetCurrentUser() string {
 
 	return userName
 }
+
+// ExpandHome replaces a leading ~/ in a path on the workstation with the home directory
+func ExpandHome(localPath string) (string, error) {
+	if !strings.HasPrefix(localPath, "~/") {
+		return localPath, nil
+	}
+
+	userHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userHomeDir, strings.TrimPrefix(localPath, "~/")), nil
+}